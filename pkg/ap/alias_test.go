@@ -0,0 +1,42 @@
+package ap_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/PennState/additional-properties/pkg/ap"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// aliasExtension accepts "legacyA" as an alternative input name for the
+// fieldA field of Simple.
+type aliasExtension struct {
+	jsoniter.DummyExtension
+}
+
+func (e *aliasExtension) UpdateStructDescriptor(desc *jsoniter.StructDescriptor) {
+	if desc.Type.Type1() != reflect.TypeOf(Simple{}) {
+		return
+	}
+	for _, binding := range desc.Fields {
+		if binding.Field.Name() == "FieldA" {
+			binding.FromNames = append(binding.FromNames, "legacyA")
+		}
+	}
+}
+
+func TestFieldAliases(t *testing.T) {
+	json := ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze())
+	json.RegisterExtension(&aliasExtension{})
+
+	for _, data := range []string{
+		`{"fieldA":"Field A","fieldB":"Field B","fieldC":"Field C"}`,
+		`{"legacyA":"Field A","fieldB":"Field B","fieldC":"Field C"}`,
+	} {
+		var s Simple
+		require.NoError(t, json.Unmarshal([]byte(data), &s))
+		assert.Equal(t, NewTestSimple(), &s)
+	}
+}
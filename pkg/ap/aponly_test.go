@@ -0,0 +1,64 @@
+package ap_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/PennState/additional-properties/pkg/ap"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// APOnly declares nothing but the wildcard field, so it behaves like an
+// open-ended map.
+type APOnly struct {
+	AP map[string]json.RawMessage `json:"*"`
+}
+
+func NewZeroAPOnly() interface{} {
+	return &APOnly{}
+}
+
+func NewTestAPOnlyEmpty() interface{} {
+	return &APOnly{
+		AP: map[string]json.RawMessage{},
+	}
+}
+
+func NewTestAPOnlySingle() interface{} {
+	return &APOnly{
+		AP: map[string]json.RawMessage{
+			"fieldA": json.RawMessage([]byte("\"Field A\"")),
+		},
+	}
+}
+
+func NewTestAPOnlyMultiple() interface{} {
+	return &APOnly{
+		AP: map[string]json.RawMessage{
+			"fieldA": json.RawMessage([]byte("\"Field A\"")),
+			"fieldB": json.RawMessage([]byte("\"Field B\"")),
+			"fieldC": json.RawMessage([]byte("\"Field C\"")),
+		},
+	}
+}
+
+// TestAPOnlyEncoding checks the exact bytes, rather than JSON equality,
+// so that stray separators from the field bookkeeping would be caught.
+// The output is deterministic so that several entries can be checked.
+func TestAPOnlyEncoding(t *testing.T) {
+	json := ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze(), ap.WithDeterministicOutput())
+
+	actual, err := json.Marshal(NewTestAPOnlyEmpty())
+	require.NoError(t, err)
+	assert.Equal(t, `{}`, string(actual))
+
+	actual, err = json.Marshal(NewTestAPOnlySingle())
+	require.NoError(t, err)
+	assert.Equal(t, `{"fieldA":"Field A"}`, string(actual))
+
+	actual, err = json.Marshal(NewTestAPOnlyMultiple())
+	require.NoError(t, err)
+	assert.Equal(t, `{"fieldA":"Field A","fieldB":"Field B","fieldC":"Field C"}`, string(actual))
+}
@@ -0,0 +1,125 @@
+package ap_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/PennState/additional-properties/pkg/ap"
+	jsoniter "github.com/json-iterator/go"
+)
+
+// apPayload returns a JSON object with a single named field matching
+// Simple and n additional properties.
+func apPayload(n int) []byte {
+	var sb strings.Builder
+	sb.WriteString(`{"fieldA":"Field A"`)
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, `,"ap%d":"AP %d"`, i, i)
+	}
+	sb.WriteString("}")
+	return []byte(sb.String())
+}
+
+func BenchmarkUnmarshalAdditionalProperties(b *testing.B) {
+	json := ap.ConfigCompatibleWithStandardLibrary
+	for _, n := range []int{0, 4, 16, 64} {
+		data := apPayload(n)
+		b.Run(fmt.Sprintf("%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				var s Simple
+				if err := json.Unmarshal(data, &s); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// repeatedPayload returns a JSON object whose additional properties all
+// have one of a handful of values.
+func repeatedPayload(n int) []byte {
+	var sb strings.Builder
+	sb.WriteString(`{"fieldA":"Field A"`)
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, `,"ap%d":"a fairly long enumerated value %d"`, i, i%4)
+	}
+	sb.WriteString("}")
+	return []byte(sb.String())
+}
+
+func BenchmarkUnmarshalRepeatedValues(b *testing.B) {
+	data := repeatedPayload(64)
+	apis := []struct {
+		Name string
+		API  jsoniter.API
+	}{
+		{"Default", ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze())},
+		{"Interned", ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze(), ap.WithValueInterning())},
+	}
+	for _, a := range apis {
+		json := a.API
+		b.Run(a.Name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				var s Simple
+				if err := json.Unmarshal(data, &s); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkUnmarshalNoAP(b *testing.B) {
+	data := []byte(`{"fieldA":"Field A","fieldB":"Field B","fieldC":"Field C"}`)
+	apis := []struct {
+		Name string
+		API  jsoniter.API
+	}{
+		{"Without extension", jsoniter.ConfigCompatibleWithStandardLibrary},
+		{"With extension", ap.ConfigCompatibleWithStandardLibrary},
+	}
+	for _, a := range apis {
+		json := a.API
+		b.Run(a.Name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				var v NoAP
+				if err := json.Unmarshal(data, &v); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkMarshalRawAdditionalProperties(b *testing.B) {
+	v := APOnly{AP: map[string]json.RawMessage{}}
+	for i := 0; i < 16; i++ {
+		v.AP[fmt.Sprintf("str%d", i)] = json.RawMessage(fmt.Sprintf(`"AP %d"`, i))
+		v.AP[fmt.Sprintf("num%d", i)] = json.RawMessage(fmt.Sprintf(`%d.5`, i))
+		v.AP[fmt.Sprintf("obj%d", i)] = json.RawMessage(fmt.Sprintf(`{"n":%d,"list":[1,2]}`, i))
+	}
+	apis := []struct {
+		Name string
+		API  jsoniter.API
+	}{
+		// Raw values are passed through without validation or escaping.
+		{"Passthrough", ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze())},
+		{"Validated", ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{ValidateJsonRawMessage: true}.Froze())},
+	}
+	for _, a := range apis {
+		json := a.API
+		b.Run(a.Name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := json.Marshal(v); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
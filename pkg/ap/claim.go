@@ -0,0 +1,158 @@
+package ap
+
+import (
+	"fmt"
+	"reflect"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/modern-go/reflect2"
+)
+
+// claim reserves a struct type, and the structs embedded in it, for the
+// goroutine describing it.  jsoniter describes a type afresh for every
+// codec it builds, and a descriptor is only complete once jsoniter has
+// built the type's codec from it, so the goroutine which described a
+// type is the only one that can decorate it from the cached descriptor.
+// Claiming the type until then means that only one goroutine describes
+// it at a time, including as an embedded struct, and so the descriptor
+// cached for a claimed type is always the one it's decorated from.
+type claim struct {
+	Type     string
+	Encoding bool
+}
+
+// CreateDecoder claims the struct types whose decoders jsoniter builds,
+// deferring to a lazy decoder if another goroutine holds the claim.
+func (e *additionalPropertiesExtension) CreateDecoder(typ reflect2.Type) jsoniter.ValDecoder {
+	if typ.Kind() != reflect.Struct || customDecoding(typ) || e.claim(typ, false) {
+		return nil
+	}
+	return &lazyCodec{Ext: e, Name: typeName(typ), Type: typ}
+}
+
+// CreateEncoder replaces the encoders of floats with WithStandardFloats
+// (see floatEncoderOf) and claims the struct types whose encoders
+// jsoniter builds, deferring to a lazy encoder if another goroutine
+// holds the claim.
+//
+// Types with a MarshalJSON (or MarshalText) method aren't claimed.  With
+// a value receiver they're never described, and with a pointer receiver
+// they're only described as the value being marshaled (see
+// customEncoding), which extensions aren't told, so a lazy encoder
+// couldn't stand in for them.  Marshaling the latter for the first time
+// concurrently can still decorate it from another goroutine's
+// descriptor.
+func (e *additionalPropertiesExtension) CreateEncoder(typ reflect2.Type) jsoniter.ValEncoder {
+	if e.Options.StdFloats {
+		if enc := floatEncoderOf(typ); enc != nil {
+			return enc
+		}
+	}
+	if typ.Kind() != reflect.Struct || customEncoding(typ, false) || e.claim(typ, true) {
+		return nil
+	}
+	return &lazyCodec{Ext: e, Name: typeName(typ), Type: typ, Encoding: true}
+}
+
+// claim claims typ (see claim) if it may have an AP field, returning
+// false if another goroutine, or an enclosing description of the same
+// type, already holds the claim.  The descriptor previously cached for
+// the type is discarded, so that the type is only decorated from one
+// described under the claim.
+func (e *additionalPropertiesExtension) claim(typ reflect2.Type, encoding bool) bool {
+	if _, ok := e.Plain.Load(typ.Type1()); ok {
+		return true
+	}
+	names := e.claimedTypes(typ.Type1(), map[reflect.Type]bool{})
+	if len(names) == 0 {
+		return true
+	}
+
+	e.Mutex.Lock()
+	defer e.Mutex.Unlock()
+	for _, name := range names {
+		if e.Claims[name] != nil {
+			e.Options.Log.Debug("Deferring description - claimed: ", name)
+			return false
+		}
+	}
+	c := &claim{Type: names[0], Encoding: encoding}
+	for _, name := range names {
+		e.Claims[name] = c
+	}
+	delete(e.Desc, names[0])
+	return true
+}
+
+// release releases the claim on the named type made when building its
+// decoder or encoder, returning false if there isn't one.
+func (e *additionalPropertiesExtension) release(name string, encoding bool) bool {
+	c := e.Claims[name]
+	if c == nil || c.Type != name || c.Encoding != encoding {
+		return false
+	}
+	for n, held := range e.Claims {
+		if held == c {
+			delete(e.Claims, n)
+		}
+	}
+	e.Released.Broadcast()
+	return true
+}
+
+// claimedTypes returns the names of typ and of the structs embedded in
+// it which may have an AP field, or nothing if typ itself may not.
+func (e *additionalPropertiesExtension) claimedTypes(typ reflect.Type, seen map[reflect.Type]bool) []string {
+	if seen[typ] {
+		return nil
+	}
+	seen[typ] = true
+	var names []string
+	for _, embedded := range embeddedStructs(typ) {
+		elem := typ.Field(embedded.Index).Type
+		if embedded.Ptr {
+			elem = elem.Elem()
+		}
+		names = append(names, e.claimedTypes(elem, seen)...)
+	}
+	if len(names) == 0 && !e.mayHaveAPField(typ) {
+		return nil
+	}
+	return append([]string{typeName(reflect2.Type2(typ))}, names...)
+}
+
+// mayHaveAPField determines whether the struct type typ declares a field
+// marked as its AP field, going by the field's json tag.
+func (e *additionalPropertiesExtension) mayHaveAPField(typ reflect.Type) bool {
+	marker := e.Options.marker(typeName(reflect2.Type2(typ)))
+	str := reflect2.Type2(typ).(reflect2.StructType)
+	for i := 0; i < str.NumField(); i++ {
+		f := str.Field(i)
+		if f.Anonymous() && f.Type() == additionalPropertiesType {
+			return true
+		}
+		if isMarked(f, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// describe has jsoniter describe the type of c afresh, as the field of a
+// struct type it hasn't built codecs for before, since the codecs it
+// has already built are cached.  This is how a lazy codec standing in
+// for a claimed type is resolved when the claim only described the type
+// as a struct embedded in another.  The holder is the nth for the type,
+// so the holders minted for a type are reused by other APIs.
+func (e *additionalPropertiesExtension) describe(c *lazyCodec, n int) {
+	holder := reflect2.Type2(reflect.StructOf([]reflect.StructField{{
+		Name: "V",
+		Type: c.Type.Type1(),
+		Tag:  reflect.StructTag(fmt.Sprintf(`ap:"%d"`, n)),
+	}}))
+	if c.Encoding {
+		e.API.EncoderOf(holder)
+	} else {
+		e.API.DecoderOf(reflect2.PtrTo(holder))
+	}
+}
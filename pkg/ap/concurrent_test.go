@@ -0,0 +1,174 @@
+package ap_test
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PennState/additional-properties/pkg/ap"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+)
+
+// quietLogger discards everything, since the standard logger's locking
+// would serialize the goroutines of TestConcurrentFirstUse.
+type quietLogger struct{}
+
+func (quietLogger) Trace(...interface{}) {}
+func (quietLogger) Debug(...interface{}) {}
+func (quietLogger) Warn(...interface{})  {}
+func (quietLogger) Error(...interface{}) {}
+
+// slowExtension is registered after the AP extension, widening the window
+// between it describing a type and jsoniter finishing the descriptor.
+type slowExtension struct {
+	jsoniter.DummyExtension
+}
+
+func (*slowExtension) UpdateStructDescriptor(*jsoniter.StructDescriptor) {
+	time.Sleep(time.Millisecond)
+}
+
+// TestConcurrentFirstUse marshals and unmarshals types from many
+// goroutines at once with fresh APIs, so that the types are described
+// and decorated concurrently.  It's most useful with -race.
+func TestConcurrentFirstUse(t *testing.T) {
+	data := []byte(`{"fieldA":"Field A","fieldB":"Field B"}`)
+	for i := 0; i < 20; i++ {
+		api := ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze(), ap.WithLogger(quietLogger{}))
+		api.RegisterExtension(&slowExtension{})
+		start := make(chan struct{})
+		var wg sync.WaitGroup
+		for j := 0; j < 8; j++ {
+			wg.Add(1)
+			go func(j int) {
+				defer wg.Done()
+				<-start
+				switch j % 4 {
+				case 0:
+					var s Simple
+					assert.NoError(t, api.Unmarshal(data, &s))
+					assert.Equal(t, "Field A", s.FieldA)
+				case 1:
+					actual, err := api.Marshal(NewTestSimple())
+					assert.NoError(t, err)
+					assert.JSONEq(t, `{"fieldA":"Field A","fieldB":"Field B","fieldC":"Field C"}`, string(actual))
+				case 2:
+					var o Outer
+					assert.NoError(t, api.Unmarshal(data, &o))
+					_, err := api.Marshal(o)
+					assert.NoError(t, err)
+				default:
+					var n Node
+					assert.NoError(t, api.Unmarshal([]byte(`{"name":"n","children":[{"name":"c","x":1}]}`), &n))
+					_, err := api.Marshal(n)
+					assert.NoError(t, err)
+				}
+			}(j)
+		}
+		close(start)
+		wg.Wait()
+	}
+}
+
+// TestConcurrentColdStart unmarshals the same never-before-seen types
+// from many goroutines at once, each of which must collect the
+// additional properties, including those promoted from an embedded
+// struct.
+func TestConcurrentColdStart(t *testing.T) {
+	data := []byte(`{"fieldA":"Field A","fieldB":"Field B","fieldC":"Field C"}`)
+	for i := 0; i < 20; i++ {
+		api := ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze(), ap.WithLogger(quietLogger{}))
+		api.RegisterExtension(&slowExtension{})
+		start := make(chan struct{})
+		var wg sync.WaitGroup
+		for j := 0; j < 16; j++ {
+			wg.Add(1)
+			go func(j int) {
+				defer wg.Done()
+				<-start
+				if j%2 == 0 {
+					var s Simple
+					assert.NoError(t, api.Unmarshal(data, &s))
+					assert.Equal(t, NewTestSimple(), &s)
+					return
+				}
+				var o Outer
+				assert.NoError(t, api.Unmarshal(data, &o))
+				assert.Len(t, o.AP, 2)
+			}(j)
+		}
+		close(start)
+		wg.Wait()
+	}
+}
+
+// gateExtension blocks the first description of a NotAMap until Open is
+// closed, holding the AP extension's claim on it.
+type gateExtension struct {
+	jsoniter.DummyExtension
+	Entered chan struct{}
+	Open    chan struct{}
+	once    sync.Once
+}
+
+func (g *gateExtension) UpdateStructDescriptor(desc *jsoniter.StructDescriptor) {
+	if desc.Type.Type1() != reflect.TypeOf(NotAMap{}) {
+		return
+	}
+	g.once.Do(func() {
+		close(g.Entered)
+		<-g.Open
+	})
+}
+
+// deferralLogger closes Deferred when a description is deferred.
+type deferralLogger struct {
+	quietLogger
+	Deferred chan struct{}
+	once     sync.Once
+}
+
+func (l *deferralLogger) Debug(args ...interface{}) {
+	if s, ok := args[0].(string); ok && strings.HasPrefix(s, "Deferring description") {
+		l.once.Do(func() { close(l.Deferred) })
+	}
+}
+
+// TestConcurrentFirstUseWithoutAPMap marshals a type with a wildcard
+// field that isn't a map while unmarshaling it for the first time, so the
+// lazy decoder standing in for it finds the type marked as having no AP
+// field once the encoder's claim is released.
+func TestConcurrentFirstUseWithoutAPMap(t *testing.T) {
+	logger := &deferralLogger{Deferred: make(chan struct{})}
+	api := ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze(), ap.WithLogger(logger))
+	gate := &gateExtension{Entered: make(chan struct{}), Open: make(chan struct{})}
+	api.RegisterExtension(gate)
+
+	marshaled := make(chan struct{})
+	go func() {
+		defer close(marshaled)
+		actual, err := api.Marshal(NotAMap{FieldA: "Field A"})
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"fieldA":"Field A","*":""}`, string(actual))
+	}()
+	<-gate.Entered
+
+	unmarshaled := make(chan NotAMap)
+	go func() {
+		var v NotAMap
+		assert.NoError(t, api.Unmarshal([]byte(`{"fieldA":"Field A","fieldB":"Field B"}`), &v))
+		unmarshaled <- v
+	}()
+	<-logger.Deferred
+	close(gate.Open)
+	<-marshaled
+	select {
+	case v := <-unmarshaled:
+		assert.Equal(t, NotAMap{FieldA: "Field A"}, v)
+	case <-time.After(5 * time.Second):
+		t.Fatal("lazy decoder didn't resolve")
+	}
+}
@@ -0,0 +1,41 @@
+package ap_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PennState/additional-properties/pkg/ap"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// nested returns an object whose "deep" property nests depth objects and
+// arrays (each counting as a level).
+func nested(depth int) []byte {
+	return []byte(`{"fieldA":"Field A","deep":` +
+		strings.Repeat(`[{"a":`, depth/2) + `1` + strings.Repeat(`}]`, depth/2) + `}`)
+}
+
+func TestDeeplyNestedAdditionalProperty(t *testing.T) {
+	// jsoniter bounds the nesting of skipped values, which is how raw AP
+	// values are captured, so excessive depth is an error rather than a
+	// stack overflow.  Interned values are captured the same way.
+	apis := map[string]jsoniter.API{
+		"Raw":      ap.ConfigCompatibleWithStandardLibrary,
+		"Interned": ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze(), ap.WithValueInterning()),
+	}
+	for name, api := range apis {
+		api := api
+		t.Run(name, func(t *testing.T) {
+			var s Simple
+			require.NoError(t, api.Unmarshal(nested(1000), &s))
+			assert.Len(t, s.AP["deep"], len(nested(1000))-len(`{"fieldA":"Field A","deep":}`))
+
+			err := api.Unmarshal(nested(100000), &s)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), `error decoding additional property "deep" of ap_test.Simple: `)
+			assert.Contains(t, err.Error(), "exceeded max depth")
+		})
+	}
+}
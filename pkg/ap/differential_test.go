@@ -0,0 +1,174 @@
+package ap_test
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/PennState/additional-properties/pkg/ap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// assertMatchesEncodingJSON asserts that v, which shouldn't have any
+// additional properties, is marshaled by the AP extension exactly as
+// encoding/json marshals it, apart from the order of object keys.  The
+// AP fields of the types passed must be tagged "*,omitempty", which
+// encoding/json omits when they're empty.
+func assertMatchesEncodingJSON(t *testing.T, v interface{}) {
+	t.Helper()
+	expected, err := json.Marshal(v)
+	require.NoError(t, err)
+	actual, err := ap.ConfigCompatibleWithStandardLibrary.Marshal(v)
+	require.NoError(t, err)
+	assert.Equal(t, string(sortKeys(t, expected)), string(sortKeys(t, actual)))
+}
+
+// sortKeys sorts the keys of every object in data, leaving the encoding
+// of everything else untouched.
+func sortKeys(t *testing.T, data json.RawMessage) json.RawMessage {
+	t.Helper()
+	switch {
+	case len(data) > 0 && data[0] == '{':
+		var obj map[string]json.RawMessage
+		require.NoError(t, json.Unmarshal(data, &obj))
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		sorted := []byte{'{'}
+		for i, k := range keys {
+			if i > 0 {
+				sorted = append(sorted, ',')
+			}
+			key, err := json.Marshal(k)
+			require.NoError(t, err)
+			sorted = append(sorted, key...)
+			sorted = append(sorted, ':')
+			sorted = append(sorted, sortKeys(t, obj[k])...)
+		}
+		return append(sorted, '}')
+	case len(data) > 0 && data[0] == '[':
+		var arr []json.RawMessage
+		require.NoError(t, json.Unmarshal(data, &arr))
+		sorted := []byte{'['}
+		for i, elem := range arr {
+			if i > 0 {
+				sorted = append(sorted, ',')
+			}
+			sorted = append(sorted, sortKeys(t, elem)...)
+		}
+		return append(sorted, ']')
+	default:
+		return data
+	}
+}
+
+// The corpus of struct shapes compared against encoding/json.
+
+type DiffScalars struct {
+	String  string                     `json:"string"`
+	HTML    string                     `json:"html"`
+	Unicode string                     `json:"unicode"`
+	Int     int                        `json:"int"`
+	Uint    uint64                     `json:"uint"`
+	Float   float64                    `json:"float"`
+	Small   float32                    `json:"small"`
+	Tiny    float64                    `json:"tiny"`
+	Bool    bool                       `json:"bool"`
+	Bytes   []byte                     `json:"bytes"`
+	AP      map[string]json.RawMessage `json:"*,omitempty"`
+}
+
+type DiffOmitEmpty struct {
+	String  string                     `json:"string,omitempty"`
+	Int     int                        `json:"int,omitempty"`
+	Float   float64                    `json:"float,omitempty"`
+	Bool    bool                       `json:"bool,omitempty"`
+	Pointer *string                    `json:"pointer,omitempty"`
+	Slice   []int                      `json:"slice,omitempty"`
+	Map     map[string]int             `json:"map,omitempty"`
+	Iface   interface{}                `json:"iface,omitempty"`
+	Struct  struct{}                   `json:"struct,omitempty"`
+	Time    time.Time                  `json:"time,omitempty"`
+	AP      map[string]json.RawMessage `json:"*,omitempty"`
+}
+
+type DiffNames struct {
+	Untagged string
+	Renamed  string                     `json:"renamed"`
+	Ignored  string                     `json:"-"`
+	Dash     string                     `json:"-,"`
+	Quoted   int                        `json:"quoted,string"`
+	AP       map[string]json.RawMessage `json:"*,omitempty"`
+}
+
+type DiffEmbeddedBase struct {
+	Base   string `json:"base"`
+	Shadow string `json:"shadow"`
+}
+
+type DiffEmbedded struct {
+	DiffEmbeddedBase
+	*DiffScalars `json:"scalars,omitempty"`
+	Shadow       string                     `json:"shadow"`
+	AP           map[string]json.RawMessage `json:"*,omitempty"`
+}
+
+type DiffComposite struct {
+	Nil     []string                   `json:"nil"`
+	Empty   []string                   `json:"empty"`
+	Keyed   map[int]string             `json:"keyed"`
+	Nested  *DiffScalars               `json:"nested"`
+	Missing *DiffScalars               `json:"missing"`
+	Times   []time.Time                `json:"times"`
+	Number  json.Number                `json:"number"`
+	Raw     json.RawMessage            `json:"raw"`
+	Iface   interface{}                `json:"iface"`
+	AP      map[string]json.RawMessage `json:"*,omitempty"`
+}
+
+func TestMatchesEncodingJSON(t *testing.T) {
+	scalars := &DiffScalars{
+		String:  "plain",
+		HTML:    "<a href=\"x\">&</a>",
+		Unicode: "é  \x01�",
+		Int:     -42,
+		Uint:    math.MaxUint64,
+		Float:   1e21,
+		Small:   0.0000001,
+		Tiny:    5e-7,
+		Bool:    true,
+		Bytes:   []byte("bytes"),
+	}
+	when := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	corpus := map[string]interface{}{
+		"Scalars":          scalars,
+		"Zero scalars":     &DiffScalars{},
+		"Omitted empties":  &DiffOmitEmpty{},
+		"Present empties":  &DiffOmitEmpty{String: "s", Int: 1, Pointer: new(string), Slice: []int{1}, Time: when},
+		"Names":            &DiffNames{Untagged: "u", Renamed: "r", Ignored: "i", Dash: "d", Quoted: 5},
+		"Embedded":         &DiffEmbedded{DiffEmbeddedBase: DiffEmbeddedBase{Base: "b", Shadow: "hidden"}, Shadow: "s"},
+		"Embedded pointer": &DiffEmbedded{DiffScalars: scalars},
+		"Composite": &DiffComposite{
+			Empty:  []string{},
+			Keyed:  map[int]string{2: "two", 10: "ten"},
+			Nested: scalars,
+			Times:  []time.Time{when},
+			Number: "1.50",
+			Raw:    json.RawMessage(`{"b":1,"a":[2]}`),
+			Iface:  map[string]interface{}{"html": "<>"},
+		},
+		"Slice":    []DiffScalars{*scalars, {}},
+		"By value": *scalars,
+	}
+	for name, v := range corpus {
+		v := v
+		t.Run(name, func(t *testing.T) {
+			assertMatchesEncodingJSON(t, v)
+		})
+	}
+}
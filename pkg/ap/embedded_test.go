@@ -1,6 +1,16 @@
 package ap_test
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/PennState/additional-properties/pkg/ap"
+	"github.com/PennState/proctor/pkg/goldenfile"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
 
 // Inner is the most basic struct to which we can add additional-
 // properties.
@@ -33,3 +43,254 @@ func NewTestOuter() interface{} {
 		FieldD: "Field D",
 	}
 }
+
+// Flattened embeds a struct with an AP field between its own fields, so
+// the embedded struct isn't at the start of the embedding struct.
+type Flattened struct {
+	FieldP string `json:"fieldP"`
+	Inner
+	FieldD string `json:"fieldD"`
+}
+
+func NewZeroFlattened() interface{} {
+	return &Flattened{}
+}
+
+func NewTestFlattened() interface{} {
+	return &Flattened{
+		FieldP: "Field P",
+		Inner: Inner{
+			FieldA: "Field A",
+			AP: map[string]json.RawMessage{
+				"fieldB": json.RawMessage([]byte("\"Field B\"")),
+				"fieldC": json.RawMessage([]byte("\"Field C\"")),
+			},
+		},
+		FieldD: "Field D",
+	}
+}
+
+func TestFlattenedAfterEmbeddedStruct(t *testing.T) {
+	// Using the embedded struct first caches its descriptor before the
+	// embedding struct is described.
+	json := ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze())
+	_, err := json.Marshal(&Inner{})
+	require.NoError(t, err)
+
+	fp := goldenfile.GetDefaultFilePath("flattened.json")
+	data, err := ioutil.ReadFile(fp)
+	require.NoError(t, err)
+	z := NewZeroFlattened()
+	require.NoError(t, json.Unmarshal(data, z))
+	assert.Equal(t, NewTestFlattened(), z)
+
+	actual, err := json.Marshal(z)
+	require.NoError(t, err)
+	goldenfile.AssertJSONEq(t, fp, string(actual))
+
+	// The embedded struct is still decoded correctly on its own.
+	var i Inner
+	require.NoError(t, json.Unmarshal(data, &i))
+	assert.Equal(t, "Field A", i.FieldA)
+	assert.Len(t, i.AP, 4)
+}
+
+// ShadowsBefore declares a field which shadows an omitempty field of the
+// embedded struct before embedding it.
+type ShadowsBefore struct {
+	Empty string `json:"empty"`
+	Inner
+}
+
+// ShadowsAfter declares a field which shadows an omitempty field of the
+// embedded struct after embedding it.
+type ShadowsAfter struct {
+	Inner
+	Empty string `json:"empty"`
+}
+
+func TestShadowedOmitEmpty(t *testing.T) {
+	api := ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze(), ap.WithDeterministicOutput())
+	inner := Inner{
+		FieldA: "Field A",
+		AP:     map[string]json.RawMessage{"fieldB": json.RawMessage(`"Field B"`)},
+	}
+
+	// The shallower field isn't omitempty, so it's written even though
+	// it's empty.
+	for _, v := range []interface{}{ShadowsBefore{Inner: inner}, ShadowsAfter{Inner: inner}} {
+		actual, err := api.Marshal(v)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"empty":"","fieldA":"Field A","fieldB":"Field B"}`, string(actual))
+	}
+}
+
+// Leaf, Branch and Twin declare identical fields, so only their depth
+// in Forked tells them apart.
+type Leaf struct {
+	X string `json:"x"`
+}
+
+type Branch struct {
+	Leaf
+}
+
+type Twin struct {
+	X string `json:"x"`
+}
+
+// Forked embeds x at depth 3 (through Branch) before it embeds x at
+// depth 2 (through Twin), which shadows it.
+type Forked struct {
+	Branch
+	Twin
+	AP map[string]json.RawMessage `json:"*"`
+}
+
+func TestShadowedIdenticalField(t *testing.T) {
+	api := ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze())
+	var f Forked
+	require.NoError(t, api.Unmarshal([]byte(`{"x":"Twin","y":"Y"}`), &f))
+	assert.Equal(t, Forked{Twin: Twin{X: "Twin"}, AP: map[string]json.RawMessage{"y": json.RawMessage(`"Y"`)}}, f)
+
+	f.Branch.X = "Leaf"
+	actual, err := api.Marshal(f)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"x":"Twin","y":"Y"}`, string(actual))
+}
+
+// Middle embeds Inner after a field of its own and is itself embedded
+// by Deep, so the AP field is promoted through two levels at non-zero
+// offsets.
+type Middle struct {
+	FieldM int `json:"fieldM"`
+	Inner
+}
+
+type Deep struct {
+	FieldP string `json:"fieldP"`
+	Middle
+	FieldD string `json:"fieldD"`
+}
+
+// Pointed embeds a pointer to a struct with an AP field.
+type Pointed struct {
+	FieldP string `json:"fieldP"`
+	*Inner
+}
+
+func TestPromotedAPFieldLocation(t *testing.T) {
+	api := ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze())
+	data := []byte(`{"fieldP":"Field P","fieldM":7,"fieldA":"Field A","fieldB":"Field B","fieldD":"Field D"}`)
+
+	var d Deep
+	require.NoError(t, api.Unmarshal(data, &d))
+	assert.Equal(t, Deep{
+		FieldP: "Field P",
+		Middle: Middle{
+			FieldM: 7,
+			Inner: Inner{
+				FieldA: "Field A",
+				AP:     map[string]json.RawMessage{"fieldB": json.RawMessage(`"Field B"`)},
+			},
+		},
+		FieldD: "Field D",
+	}, d)
+	ap, ok := ap.AdditionalPropertiesOf(&d)
+	require.True(t, ok)
+	assert.Equal(t, d.Middle.Inner.AP, ap)
+
+	actual, err := api.Marshal(d)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(data), string(actual))
+
+	data = []byte(`{"fieldP":"Field P","fieldA":"Field A","fieldB":"Field B"}`)
+	var p Pointed
+	require.NoError(t, api.Unmarshal(data, &p))
+	require.NotNil(t, p.Inner)
+	assert.Equal(t, "Field P", p.FieldP)
+	assert.Equal(t, "Field A", p.FieldA)
+	assert.Equal(t, map[string]json.RawMessage{"fieldB": json.RawMessage(`"Field B"`)}, p.AP)
+
+	actual, err = api.Marshal(p)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(data), string(actual))
+
+	// A nil embedded struct has no additional properties to write.
+	actual, err = api.Marshal(Pointed{FieldP: "Field P"})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"fieldP":"Field P"}`, string(actual))
+
+	// Nor is it allocated when decoding finds none, so it round-trips.
+	data = []byte(`{"fieldP":"Field P"}`)
+	p = Pointed{}
+	require.NoError(t, api.Unmarshal(data, &p))
+	assert.Nil(t, p.Inner)
+	actual, err = api.Marshal(p)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(data), string(actual))
+}
+
+// Named embeds Inner under a JSON name, so Inner is a named field rather
+// than a promoted one, and its AP field isn't Named's.
+type Named struct {
+	Inner  `json:"inner"`
+	FieldN string `json:"fieldN"`
+}
+
+func TestEmbeddingKinds(t *testing.T) {
+	api := ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze())
+	data := []byte(`{"fieldA":"Field A","fieldB":"Field B"}`)
+	tests := []struct {
+		Name  string
+		Zero  func() interface{}
+		Inner func(v interface{}) *Inner
+	}{
+		{"Value-embedded", func() interface{} { return &Outer{} }, func(v interface{}) *Inner { return &v.(*Outer).Inner }},
+		{"Pointer-embedded", func() interface{} { return &Pointed{} }, func(v interface{}) *Inner { return v.(*Pointed).Inner }},
+		{"Multi-level", func() interface{} { return &Deep{} }, func(v interface{}) *Inner { return &v.(*Deep).Middle.Inner }},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			v := test.Zero()
+			require.NoError(t, api.Unmarshal(data, v))
+			inner := test.Inner(v)
+			require.NotNil(t, inner)
+			assert.Equal(t, map[string]json.RawMessage{"fieldB": json.RawMessage(`"Field B"`)}, inner.AP)
+
+			// The AP field is discovered the same way by reflection.
+			m, ok := ap.AdditionalPropertiesOf(v)
+			require.True(t, ok)
+			assert.Equal(t, inner.AP, m)
+
+			// The promoted omitempty field is only written when it's set.
+			actual, err := api.Marshal(v)
+			require.NoError(t, err)
+			assert.NotContains(t, string(actual), `"empty"`)
+			assert.Contains(t, string(actual), `"fieldB":"Field B"`)
+			inner.Empty = "set"
+			actual, err = api.Marshal(v)
+			require.NoError(t, err)
+			assert.Contains(t, string(actual), `"empty":"set"`)
+		})
+	}
+
+	t.Run("Named", func(t *testing.T) {
+		var n Named
+		require.NoError(t, api.Unmarshal([]byte(`{"inner":{"fieldA":"Field A","fieldB":"Field B"},"fieldN":"Field N","fieldX":"Field X"}`), &n))
+		assert.Equal(t, Named{
+			Inner: Inner{
+				FieldA: "Field A",
+				AP:     map[string]json.RawMessage{"fieldB": json.RawMessage(`"Field B"`)},
+			},
+			FieldN: "Field N",
+		}, n)
+		_, ok := ap.AdditionalPropertiesOf(&n)
+		assert.False(t, ok)
+
+		actual, err := api.Marshal(n)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"inner":{"fieldA":"Field A","fieldB":"Field B"},"fieldN":"Field N"}`, string(actual))
+	})
+}
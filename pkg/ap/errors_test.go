@@ -0,0 +1,89 @@
+package ap_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/PennState/additional-properties/pkg/ap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type Person struct {
+	Name string                     `json:"name"`
+	Age  int                        `json:"age"`
+	AP   map[string]json.RawMessage `json:"*"`
+}
+
+type Ages struct {
+	Name string         `json:"name"`
+	AP   map[string]int `json:"*"`
+}
+
+func TestFieldDecodeErrorContext(t *testing.T) {
+	json := ap.ConfigCompatibleWithStandardLibrary
+	var p Person
+	err := json.Unmarshal([]byte(`{"name":"Pat","age":"old"}`), &p)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `error decoding field "age" of ap_test.Person: `)
+	}
+}
+
+func TestAdditionalPropertyDecodeErrorContext(t *testing.T) {
+	json := ap.ConfigCompatibleWithStandardLibrary
+	var a Ages
+	err := json.Unmarshal([]byte(`{"name":"Pat","sam":"old"}`), &a)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `error decoding additional property "sam" of ap_test.Ages: `)
+	}
+}
+
+func TestFirstDecodeErrorIsReturned(t *testing.T) {
+	api := ap.ConfigCompatibleWithStandardLibrary
+	var p Person
+	err := api.Unmarshal([]byte(`{"age":"old","name":42,"town":"Springfield"}`), &p)
+	if assert.Error(t, err) {
+		assert.True(t, strings.HasPrefix(err.Error(), `error decoding field "age" of ap_test.Person: `), err.Error())
+		assert.NotContains(t, err.Error(), `error decoding field "name"`)
+	}
+	assert.Empty(t, p.Name)
+	assert.Empty(t, p.AP)
+}
+
+func TestFailedAdditionalPropertyIsNotStored(t *testing.T) {
+	api := ap.ConfigCompatibleWithStandardLibrary
+	var a Ages
+	err := api.Unmarshal([]byte(`{"sam":"old","name":"Pat"}`), &a)
+	if assert.Error(t, err) {
+		assert.True(t, strings.HasPrefix(err.Error(), `error decoding additional property "sam" of ap_test.Ages: `), err.Error())
+	}
+	assert.Empty(t, a.Name)
+	assert.NotContains(t, a.AP, "sam")
+}
+
+func TestRawAdditionalPropertiesAreCapturedLazily(t *testing.T) {
+	api := ap.ConfigCompatibleWithStandardLibrary
+
+	// AP values of any type are captured as is.
+	var p Person
+	data := []byte(`{"name":"Pat","age":42,"height":"tall","tags":{"x":[null,true,1e4]},"ages":[]}`)
+	require.NoError(t, api.Unmarshal(data, &p))
+	assert.Equal(t, "Pat", p.Name)
+	assert.Equal(t, 42, p.Age)
+	assert.Equal(t, map[string]json.RawMessage{
+		"height": json.RawMessage(`"tall"`),
+		"tags":   json.RawMessage(`{"x":[null,true,1e4]}`),
+		"ages":   json.RawMessage(`[]`),
+	}, p.AP)
+
+	// The same value fails for a named field.
+	err := api.Unmarshal([]byte(`{"name":"Pat","age":{"x":[null,true,1e4]}}`), &p)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `error decoding field "age" of ap_test.Person: `)
+	}
+
+	// Malformed JSON fails wherever it appears.
+	err = api.Unmarshal([]byte(`{"name":"Pat","height":{"x":]}`), &p)
+	assert.Error(t, err)
+}
@@ -0,0 +1,90 @@
+package ap_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/PennState/additional-properties/pkg/ap"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Escaped has field names that must be escaped (or are commonly escaped)
+// in JSON.
+type Escaped struct {
+	Space   string                     `json:"foo bar"`
+	Unicode string                     `json:"café"`
+	AP      map[string]json.RawMessage `json:"*"`
+}
+
+func TestEscapedKeys(t *testing.T) {
+	data := []byte(`{"foo\u0020bar":"space","caf\u00e9":"unicode",` +
+		`"tab\tkey":1,"new\nline":2,"quote\"key":3,"back\\slash":4,` +
+		`"\u0001ctrl":5,"\u2028sep":6,"emoji\ud83d\ude00":7,"naïve":8}`)
+
+	var e Escaped
+	require.NoError(t, ap.Unmarshal(data, &e))
+	assert.Equal(t, "space", e.Space)
+	assert.Equal(t, "unicode", e.Unicode)
+	assert.Equal(t, map[string]json.RawMessage{
+		"tab\tkey":        json.RawMessage("1"),
+		"new\nline":       json.RawMessage("2"),
+		"quote\"key":      json.RawMessage("3"),
+		"back\\slash":     json.RawMessage("4"),
+		"\x01ctrl":        json.RawMessage("5"),
+		"\u2028sep":       json.RawMessage("6"),
+		"emoji\U0001F600": json.RawMessage("7"),
+		"naïve":           json.RawMessage("8"),
+	}, e.AP)
+
+	actual, err := ap.Marshal(e)
+	require.NoError(t, err)
+	assert.True(t, json.Valid(actual))
+	assert.Contains(t, string(actual), `"\u0001ctrl":5`)
+
+	// encoding/json agrees with the keys as they're re-emitted
+	var std map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(actual, &std))
+	assert.Len(t, std, len(e.AP)+2)
+	assert.Equal(t, json.RawMessage(`"space"`), std["foo bar"])
+	assert.Equal(t, json.RawMessage(`"unicode"`), std["café"])
+	for k, v := range e.AP {
+		assert.Equal(t, v, std[k], k)
+	}
+}
+
+func TestHTMLEscapedValues(t *testing.T) {
+	v := Escaped{
+		Space: "<b>",
+		AP: map[string]json.RawMessage{
+			"script": json.RawMessage(`"<script>alert('&')</script>"`),
+			"nested": json.RawMessage(`{"html":["<a>", 1]}`),
+			"sep":    json.RawMessage("\"\u2028\""),
+			"plain":  json.RawMessage(`"text"`),
+		},
+	}
+	expected, err := json.Marshal(struct {
+		Space   string          `json:"foo bar"`
+		Unicode string          `json:"café"`
+		Script  json.RawMessage `json:"script"`
+		Nested  json.RawMessage `json:"nested"`
+		Sep     json.RawMessage `json:"sep"`
+		Plain   json.RawMessage `json:"plain"`
+	}{v.Space, v.Unicode, v.AP["script"], v.AP["nested"], v.AP["sep"], v.AP["plain"]})
+	require.NoError(t, err)
+
+	actual, err := ap.Marshal(v)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(expected), string(actual))
+	assert.Contains(t, string(expected), `"\u003cscript\u003ealert('\u0026')\u003c/script\u003e"`)
+	assert.Contains(t, string(actual), `"\u003cscript\u003ealert('\u0026')\u003c/script\u003e"`)
+	assert.Contains(t, string(actual), `{"html":["\u003ca\u003e", 1]}`)
+	assert.Contains(t, string(actual), `"sep":"\u2028"`)
+
+	// Without EscapeHTML, raw values are written verbatim.
+	api := ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze())
+	actual, err = api.Marshal(v)
+	require.NoError(t, err)
+	assert.Contains(t, string(actual), `"<script>alert('&')</script>"`)
+}
@@ -0,0 +1,77 @@
+package ap
+
+import (
+	"math"
+	"strconv"
+	"unsafe"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/modern-go/reflect2"
+)
+
+//nolint:gochecknoglobals
+var (
+	float32Type = reflect2.TypeOf(float32(0))
+	float64Type = reflect2.TypeOf(float64(0))
+)
+
+// floatEncoderOf returns the encoder replacing jsoniter's for float32 or
+// float64 (see WithStandardFloats), or nil for other types, which writes
+// exactly what encoding/json does.  jsoniter formats floats the same
+// way, but without trimming the exponent, so 1e-7 is written as 1e-07.
+// APIs configured with MarshalFloatWith6Digits keep their lossy
+// encoders, which jsoniter consults first, as do named float types.
+func floatEncoderOf(typ reflect2.Type) jsoniter.ValEncoder {
+	switch typ {
+	case float32Type:
+		return &floatEncoder{Bits: 32}
+	case float64Type:
+		return &floatEncoder{Bits: 64}
+	default:
+		return nil
+	}
+}
+
+// floatEncoder writes a float32 or float64, depending on Bits, using
+// the algorithm of encoding/json.
+type floatEncoder struct {
+	Bits int
+}
+
+func (e *floatEncoder) float(ptr unsafe.Pointer) float64 {
+	if e.Bits == 32 {
+		return float64(*(*float32)(ptr))
+	}
+	return *(*float64)(ptr)
+}
+
+func (e *floatEncoder) Encode(ptr unsafe.Pointer, stream *jsoniter.Stream) {
+	f := e.float(ptr)
+	// jsoniter reports unsupported values with the same error.
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		stream.WriteFloat64(f)
+		return
+	}
+
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 {
+		if e.Bits == 64 && (abs < 1e-6 || abs >= 1e21) ||
+			e.Bits == 32 && (float32(abs) < 1e-6 || float32(abs) >= 1e21) {
+			format = 'e'
+		}
+	}
+	var scratch [32]byte
+	b := strconv.AppendFloat(scratch[:0], f, format, -1, e.Bits)
+	if format == 'e' {
+		// Trim e-09 to e-9
+		if n := len(b); n >= 4 && b[n-4] == 'e' && b[n-3] == '-' && b[n-2] == '0' {
+			b[n-2] = b[n-1]
+			b = b[:n-1]
+		}
+	}
+	_, _ = stream.Write(b)
+}
+
+func (e *floatEncoder) IsEmpty(ptr unsafe.Pointer) bool {
+	return e.float(ptr) == 0
+}
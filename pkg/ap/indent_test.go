@@ -0,0 +1,61 @@
+package ap_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/PennState/additional-properties/pkg/ap"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Nested has an AP field and a child with an AP field, so indentation
+// is exercised at two depths.
+type Nested struct {
+	FieldN string                     `json:"fieldN"`
+	Child  *Simple                    `json:"child"`
+	AP     map[string]json.RawMessage `json:"*"`
+}
+
+func TestIndentation(t *testing.T) {
+	api := ap.RegisterAdditionalPropertiesExtension(
+		jsoniter.Config{IndentionStep: 2}.Froze(),
+		ap.WithDeterministicOutput(),
+	)
+	v := Nested{
+		FieldN: "Field N",
+		Child:  NewTestSimple().(*Simple),
+		AP: map[string]json.RawMessage{
+			"list": json.RawMessage(`[1,2]`),
+			"obj":  json.RawMessage(`{"k":"v"}`),
+		},
+	}
+
+	actual, err := api.Marshal(v)
+	require.NoError(t, err)
+	assert.Equal(t, `{
+  "fieldN": "Field N",
+  "child": {
+    "fieldA": "Field A",
+    "fieldB": "Field B",
+    "fieldC": "Field C"
+  },
+  "list": [1,2],
+  "obj": {"k":"v"}
+}`, string(actual))
+}
+
+func TestIndentationOfEmptyObject(t *testing.T) {
+	// Indented the same way as a jsoniter struct whose fields are all
+	// omitted.
+	omitted := struct {
+		A string `json:"a,omitempty"`
+	}{}
+	api := ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{IndentionStep: 2}.Froze())
+	actual, err := api.Marshal(APOnly{})
+	require.NoError(t, err)
+	expected, err := jsoniter.Config{IndentionStep: 2}.Froze().Marshal(omitted)
+	require.NoError(t, err)
+	assert.Equal(t, string(expected), string(actual))
+}
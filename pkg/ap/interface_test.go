@@ -0,0 +1,28 @@
+package ap_test
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// WithInterface embeds an interface, which jsoniter treats as a regular
+// field named after the interface type.
+type WithInterface struct {
+	fmt.Stringer
+	FieldA string                     `json:"fieldA"`
+	AP     map[string]json.RawMessage `json:"*"`
+}
+
+func NewZeroWithInterface() interface{} {
+	return &WithInterface{}
+}
+
+func NewTestWithInterface() interface{} {
+	return &WithInterface{
+		FieldA: "Field A",
+		AP: map[string]json.RawMessage{
+			"fieldB": json.RawMessage([]byte("\"Field B\"")),
+			"fieldC": json.RawMessage([]byte("\"Field C\"")),
+		},
+	}
+}
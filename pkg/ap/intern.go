@@ -0,0 +1,57 @@
+package ap
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// The interner stops adding values once it holds maxInternedValues of
+// them or maxInternedBytes in total, so that decoding high-cardinality
+// input can't grow it without bound.
+const (
+	maxInternedValues = 4096
+	maxInternedBytes  = 1 << 20
+)
+
+// interner deduplicates additional property values so that identical
+// byte sequences share a single backing array.  Interned values must be
+// treated as read-only since they're shared between decoded structs.
+type interner struct {
+	mutex  sync.RWMutex
+	values map[string]json.RawMessage
+	size   int
+}
+
+func newInterner() *interner {
+	return &interner{
+		values: map[string]json.RawMessage{},
+	}
+}
+
+// intern returns the shared copy of the passed bytes, copying them into
+// the interner if they haven't been seen before, or returns a private
+// copy once the interner is full.  The passed slice isn't retained, so
+// it can be reused by the caller.  The shared copy's capacity is its
+// length, so appending to it never writes to the shared array.
+func (in *interner) intern(b []byte) json.RawMessage {
+	in.mutex.RLock()
+	val, ok := in.values[string(b)]
+	in.mutex.RUnlock()
+	if ok {
+		return val
+	}
+
+	in.mutex.Lock()
+	defer in.mutex.Unlock()
+	if val, ok := in.values[string(b)]; ok {
+		return val
+	}
+	val = append(json.RawMessage(nil), b...)
+	val = val[:len(val):len(val)]
+	if len(in.values) == maxInternedValues || in.size+len(val) > maxInternedBytes {
+		return val
+	}
+	in.values[string(val)] = val
+	in.size += len(val)
+	return val
+}
@@ -0,0 +1,219 @@
+package ap
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// MarshalJCS returns the RFC 8785 JSON Canonicalization Scheme (JCS)
+// encoding of v, including its additional properties.  Object keys (of
+// both named fields and additional properties, at every level) are
+// sorted by their UTF-16 code units, numbers are serialized using the
+// ECMAScript algorithm, and strings use the minimal JCS escaping.
+//
+// The value is first marshaled with DefaultConfig and the result is
+// then canonicalized, so additional property values are canonicalized
+// recursively even though they're stored as raw JSON.  Invalid UTF-8
+// and unpaired UTF-16 surrogate escapes (e.g. "\ud800"), which can only
+// come from raw values, are errors rather than being replaced.
+func MarshalJCS(v interface{}) ([]byte, error) {
+	data, err := DefaultConfig().Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return canonicalizeJCS(data)
+}
+
+func canonicalizeJCS(data []byte) ([]byte, error) {
+	// encoding/json would replace invalid UTF-8 with U+FFFD.
+	if !utf8.Valid(data) {
+		return nil, errors.New("jcs: invalid UTF-8")
+	}
+	// As would unpaired surrogates.
+	if hasLoneSurrogate(data) {
+		return nil, errors.New("jcs: unpaired UTF-16 surrogate escape")
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var val interface{}
+	if err := dec.Decode(&val); err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	if err := writeJCS(buf, val); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// hasLoneSurrogate determines whether data has a \u escape of a UTF-16
+// surrogate that isn't part of a high-low pair.  Malformed escapes are
+// left to the decoder.
+func hasLoneSurrogate(data []byte) bool {
+	for i := 0; i < len(data); i++ {
+		if data[i] != '\\' {
+			continue
+		}
+		i++
+		r, ok := unicodeEscape(data[i:])
+		if !ok || !utf16.IsSurrogate(r) {
+			continue
+		}
+		i += 4
+		if r >= 0xdc00 {
+			return true
+		}
+		// A high surrogate must be followed by an escaped low one.
+		if i+1 >= len(data) || data[i+1] != '\\' {
+			return true
+		}
+		low, ok := unicodeEscape(data[i+2:])
+		if !ok || low < 0xdc00 || low >= 0xe000 {
+			return true
+		}
+		i += 6
+	}
+	return false
+}
+
+// unicodeEscape returns the code unit of the \u escape that data starts
+// with, after the backslash.
+func unicodeEscape(data []byte) (rune, bool) {
+	if len(data) < 5 || data[0] != 'u' {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(string(data[1:5]), 16, 16)
+	return rune(n), err == nil
+}
+
+func writeJCS(buf *bytes.Buffer, val interface{}) error {
+	switch v := val.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case json.Number:
+		f, err := strconv.ParseFloat(string(v), 64)
+		if err != nil {
+			return fmt.Errorf("jcs: number %s can't be represented as an IEEE 754 double", v)
+		}
+		return writeJCSNumber(buf, f)
+	case string:
+		writeJCSString(buf, v)
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, elem := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeJCS(buf, elem); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return lessUTF16(keys[i], keys[j])
+		})
+		buf.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeJCSString(buf, k)
+			buf.WriteByte(':')
+			if err := writeJCS(buf, v[k]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return fmt.Errorf("jcs: unexpected value of type %T", val)
+	}
+	return nil
+}
+
+// writeJCSNumber formats f as ECMAScript's Number.prototype.toString
+// would, which is the same algorithm encoding/json uses for floats.
+func writeJCSNumber(buf *bytes.Buffer, f float64) error {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return fmt.Errorf("jcs: unsupported number %v", f)
+	}
+	if f == 0 {
+		// Includes negative zero
+		buf.WriteByte('0')
+		return nil
+	}
+	format := byte('f')
+	if abs := math.Abs(f); abs < 1e-6 || abs >= 1e21 {
+		format = 'e'
+	}
+	b := strconv.AppendFloat(nil, f, format, -1, 64)
+	if format == 'e' {
+		// Clean up e-09 to e-9
+		n := len(b)
+		if n >= 4 && b[n-4] == 'e' && b[n-3] == '-' && b[n-2] == '0' {
+			b[n-2] = b[n-1]
+			b = b[:n-1]
+		}
+	}
+	buf.Write(b)
+	return nil
+}
+
+func writeJCSString(buf *bytes.Buffer, s string) {
+	const hex = "0123456789abcdef"
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				buf.WriteString(`\u00`)
+				buf.WriteByte(hex[r>>4])
+				buf.WriteByte(hex[r&0xf])
+				continue
+			}
+			buf.WriteRune(r)
+		}
+	}
+	buf.WriteByte('"')
+}
+
+// lessUTF16 compares strings by their UTF-16 code units as required by
+// JCS, which differs from Go's byte-wise ordering for characters outside
+// the Basic Multilingual Plane.
+func lessUTF16(a, b string) bool {
+	ua := utf16.Encode([]rune(a))
+	ub := utf16.Encode([]rune(b))
+	for i := 0; i < len(ua) && i < len(ub); i++ {
+		if ua[i] != ub[i] {
+			return ua[i] < ub[i]
+		}
+	}
+	return len(ua) < len(ub)
+}
@@ -0,0 +1,124 @@
+package ap_test
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/PennState/additional-properties/pkg/ap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// JCSDoc mirrors the example in RFC 8785 section 3.2.2 with the numbers
+// and string members carried as additional properties.
+type JCSDoc struct {
+	Literals []interface{}              `json:"literals"`
+	AP       map[string]json.RawMessage `json:"*"`
+}
+
+func TestMarshalJCS(t *testing.T) {
+	doc := JCSDoc{
+		Literals: []interface{}{nil, true, false},
+		AP: map[string]json.RawMessage{
+			"numbers": json.RawMessage(`[333333333.33333329, 1E30, 4.50, 2e-3, 0.000000000000000000000000001]`),
+			"string":  json.RawMessage(`"\u20ac$\u000F\u000aA'\u0042\u0022\u005c\\\"\/"`),
+		},
+	}
+	actual, err := ap.MarshalJCS(doc)
+	require.NoError(t, err)
+	expected := `{"literals":[null,true,false],"numbers":[333333333.3333333,1e+30,4.5,0.002,1e-27],"string":"€$\u000f\nA'B\"\\\\\"/"}`
+	assert.Equal(t, expected, string(actual))
+}
+
+func TestMarshalJCSInvalidUTF8(t *testing.T) {
+	doc := APOnly{AP: map[string]json.RawMessage{"bad": json.RawMessage("\"\xff\"")}}
+	_, err := ap.MarshalJCS(doc)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid UTF-8")
+}
+
+func TestMarshalJCSLoneSurrogates(t *testing.T) {
+	for _, raw := range []string{`"\ud800"`, `"\udc00"`, `"a\ud800b"`, `"\ud800\u0041"`, `"\udbff\ud800"`, `{"\ud800":1}`} {
+		doc := APOnly{AP: map[string]json.RawMessage{"bad": json.RawMessage(raw)}}
+		_, err := ap.MarshalJCS(doc)
+		require.Error(t, err, raw)
+		assert.Contains(t, err.Error(), "unpaired UTF-16 surrogate", raw)
+	}
+
+	// Pairs and escaped backslashes are left alone.
+	doc := APOnly{AP: map[string]json.RawMessage{"pair": json.RawMessage(`"\ud83d\ude00"`), "slash": json.RawMessage(`"\\ud800"`)}}
+	actual, err := ap.MarshalJCS(doc)
+	require.NoError(t, err)
+	assert.Equal(t, "{\"pair\":\"\U0001f600\",\"slash\":\"\\\\ud800\"}", string(actual))
+}
+
+// TestMarshalJCSSorting uses the property sorting example from RFC 8785
+// section 3.2.3, which requires sorting by UTF-16 code units.
+func TestMarshalJCSSorting(t *testing.T) {
+	doc := APOnly{
+		AP: map[string]json.RawMessage{
+			"\u20ac":       json.RawMessage(`"Euro Sign"`),
+			"\r":           json.RawMessage(`"Carriage Return"`),
+			"\ufb33":       json.RawMessage(`"Hebrew Letter Dalet With Dagesh"`),
+			"1":            json.RawMessage(`"One"`),
+			"\U0001F600":   json.RawMessage(`"Emoji: Grinning Face"`),
+			"\u0080":       json.RawMessage(`"Control"`),
+			"\u00f6":       json.RawMessage(`"Latin Small Letter O With Diaeresis"`),
+			"nested-order": json.RawMessage(`{"b":1,"a":{"d":2,"c":3}}`),
+		},
+	}
+	actual, err := ap.MarshalJCS(doc)
+	require.NoError(t, err)
+	expected := "{\"\\r\":\"Carriage Return\",\"1\":\"One\",\"nested-order\":{\"a\":{\"c\":3,\"d\":2},\"b\":1}," +
+		"\"\u0080\":\"Control\",\"\u00f6\":\"Latin Small Letter O With Diaeresis\",\"\u20ac\":\"Euro Sign\"," +
+		"\"\U0001F600\":\"Emoji: Grinning Face\",\"\ufb33\":\"Hebrew Letter Dalet With Dagesh\"}"
+	assert.Equal(t, expected, string(actual))
+}
+
+// TestMarshalJCSNumbers uses the number serialization samples from RFC
+// 8785 appendix B.
+func TestMarshalJCSNumbers(t *testing.T) {
+	vectors := []struct {
+		Bits     uint64
+		Expected string
+	}{
+		{0x0000000000000000, "0"},
+		{0x8000000000000000, "0"},
+		{0x0000000000000001, "5e-324"},
+		{0x8000000000000001, "-5e-324"},
+		{0x7fefffffffffffff, "1.7976931348623157e+308"},
+		{0xffefffffffffffff, "-1.7976931348623157e+308"},
+		{0x4340000000000000, "9007199254740992"},
+		{0xc340000000000000, "-9007199254740992"},
+		{0x4430000000000000, "295147905179352830000"},
+		{0x44b52d02c7e14af5, "9.999999999999997e+22"},
+		{0x44b52d02c7e14af6, "1e+23"},
+		{0x44b52d02c7e14af7, "1.0000000000000001e+23"},
+		{0x444b1ae4d6e2ef4e, "999999999999999700000"},
+		{0x444b1ae4d6e2ef4f, "999999999999999900000"},
+		{0x444b1ae4d6e2ef50, "1e+21"},
+		{0x3eb0c6f7a0b5ed8c, "9.999999999999997e-7"},
+		{0x3eb0c6f7a0b5ed8d, "0.000001"},
+		{0x41b3de4355555553, "333333333.3333332"},
+		{0x41b3de4355555554, "333333333.33333325"},
+		{0x41b3de4355555555, "333333333.3333333"},
+		{0x41b3de4355555556, "333333333.3333334"},
+		{0x41b3de4355555557, "333333333.33333343"},
+		{0xbecbf647612f3696, "-0.0000033333333333333333"},
+		{0x43143ff3c1cb0959, "1424953923781206.2"},
+	}
+	for _, v := range vectors {
+		f := math.Float64frombits(v.Bits)
+		raw := strconv.FormatFloat(f, 'g', -1, 64)
+		t.Run(raw, func(t *testing.T) {
+			doc := APOnly{
+				AP: map[string]json.RawMessage{"n": json.RawMessage(raw)},
+			}
+			actual, err := ap.MarshalJCS(doc)
+			require.NoError(t, err)
+			assert.Equal(t, `{"n":`+v.Expected+`}`, string(actual))
+		})
+	}
+}
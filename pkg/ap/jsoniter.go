@@ -1,11 +1,16 @@
 package ap
 
 import (
+	"bytes"
+	"encoding"
 	"encoding/json"
 	"fmt"
+	"io"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"unsafe"
 
 	jsoniter "github.com/json-iterator/go"
@@ -16,26 +21,196 @@ import (
 type additionalPropertiesExtension struct {
 	jsoniter.DummyExtension
 	Desc      map[string]*jsoniter.StructDescriptor
+	Described map[string]bool
 	APBinding map[string]*jsoniter.Binding
+	Decoders  map[string]jsoniter.ValDecoder
+	Encoders  map[string]jsoniter.ValEncoder
+	Errors    map[string]error
+	Warnings  map[string]error
+	Plain     sync.Map
+	Claims    map[string]*claim
+	Released  *sync.Cond
 	Mutex     *sync.Mutex
+	Options   *options
+	API       jsoniter.API
+	Holders   map[string]int
+
+	Registered sync.Once
 }
 
-func newAdditionalPropertiesExtension() *additionalPropertiesExtension {
+func newAdditionalPropertiesExtension(opts ...Option) *additionalPropertiesExtension {
+	o := &options{
+		Wildcard: defaultWildcard,
+		Log:      log.StandardLogger(),
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	mutex := &sync.Mutex{}
 	return &additionalPropertiesExtension{
 		DummyExtension: jsoniter.DummyExtension{},
 		Desc:           map[string]*jsoniter.StructDescriptor{},
+		Described:      map[string]bool{},
 		APBinding:      map[string]*jsoniter.Binding{},
-		Mutex:          &sync.Mutex{},
+		Decoders:       map[string]jsoniter.ValDecoder{},
+		Encoders:       map[string]jsoniter.ValEncoder{},
+		Errors:         map[string]error{},
+		Warnings:       map[string]error{},
+		Claims:         map[string]*claim{},
+		Holders:        map[string]int{},
+		Released:       sync.NewCond(mutex),
+		Mutex:          mutex,
+		Options:        o,
 	}
 }
 
+// registry maps each jsoniter.API to the AP extension registered with it.
+// It keeps both, and the extension's caches, until the API is
+// unregistered.
+//
+//nolint:gochecknoglobals
+var registry = sync.Map{}
+
 // RegisterAdditionalPropertiesExtension registers the AP extension with
-// the passed jsoniter.API
-func RegisterAdditionalPropertiesExtension(api jsoniter.API) jsoniter.API {
-	api.RegisterExtension(newAdditionalPropertiesExtension())
+// the passed jsoniter.API, configured by the (optional) passed Options.
+// Registering the extension with an API that already has it is a no-op,
+// and the passed Options are ignored.
+//
+// The package keeps a reference to the API, so that ResetCaches, StatsOf
+// and the like can find its extension, which lasts until it's passed to
+// UnregisterAdditionalPropertiesExtension.  APIs created for a limited
+// time (e.g. per tenant or per test) should be unregistered once they're
+// discarded.
+//
+// The extension only changes how structs with an AP field are decoded and
+// encoded, unless it's registered using WithStandardFloats, which
+// replaces the API's float encoders.
+func RegisterAdditionalPropertiesExtension(api jsoniter.API, opts ...Option) jsoniter.API {
+	actual, loaded := registry.LoadOrStore(api, newAdditionalPropertiesExtension(opts...))
+	e := actual.(*additionalPropertiesExtension)
+	if loaded {
+		e.Options.Log.Debug("Not registering - AP extension already registered")
+		if len(opts) > 0 {
+			e.Options.Log.Warn("Ignoring options - AP extension already registered")
+		}
+	}
+	// Concurrent callers wait until the extension is registered, so none
+	// of them can use the API before then.
+	e.Registered.Do(func() {
+		e.API = api
+		e.Options.EscapeHTML = escapesHTML(api)
+		e.Options.ValidateRaw = validatesRawMessages(api)
+		if e.Options.CaseSensitive != nil {
+			e.Options.FoldCase = !*e.Options.CaseSensitive
+		} else {
+			e.Options.FoldCase = foldsCase(api)
+		}
+		api.RegisterExtension(e)
+	})
 	return api
 }
 
+// UnregisterAdditionalPropertiesExtension releases the package's
+// reference to api and to the AP extension registered with it,
+// returning false if there isn't one, so that they can be garbage
+// collected once api is discarded.  jsoniter can't unregister an
+// extension, so api still decodes and encodes additional properties,
+// but the functions taking an API no longer find its extension, and it
+// mustn't be registered again.
+func UnregisterAdditionalPropertiesExtension(api jsoniter.API) bool {
+	_, ok := registry.LoadAndDelete(api)
+	return ok
+}
+
+// caseProbe is decoded by foldsCase, which happens before the extension
+// is registered, so it's never decorated.
+type caseProbe struct {
+	Field bool `json:"field"`
+}
+
+// foldsCase determines whether api matches keys to fields without regard
+// to case (i.e. it doesn't have the CaseSensitive setting), which jsoniter
+// doesn't expose, by decoding a key differing in case from the field.
+func foldsCase(api jsoniter.API) bool {
+	var probe caseProbe
+	return api.Unmarshal([]byte(`{"FIELD":true}`), &probe) == nil && probe.Field
+}
+
+// escapesHTML determines whether api has the EscapeHTML setting, which
+// jsoniter doesn't expose, by encoding a string that would be escaped.
+func escapesHTML(api jsoniter.API) bool {
+	s, err := api.MarshalToString("<")
+	return err == nil && s != `"<"`
+}
+
+// validatesRawMessages determines whether api has the
+// ValidateJsonRawMessage setting, which jsoniter doesn't expose, by
+// encoding an invalid json.RawMessage, which would be replaced by null.
+func validatesRawMessages(api jsoniter.API) bool {
+	s, err := api.MarshalToString(json.RawMessage(`}`))
+	return err == nil && s == "null"
+}
+
+// ResetCaches discards every struct descriptor and AP binding cached by
+// the AP extension registered with api, returning false if there isn't
+// one.  See EvictType for the effect of eviction.
+func ResetCaches(api jsoniter.API) bool {
+	e, ok := registry.Load(api)
+	if ok {
+		e.(*additionalPropertiesExtension).ResetCaches()
+	}
+	return ok
+}
+
+// EvictType discards the struct descriptor and AP binding cached for typ
+// by the AP extension registered with api, returning false if there
+// isn't one.
+//
+// Eviction forces the type to be re-resolved the next time jsoniter
+// describes it.  Note that the jsoniter.API also caches the decoders
+// and encoders it has already built, and these keep working (and keep
+// their references) after eviction.
+func EvictType(api jsoniter.API, typ reflect.Type) bool {
+	e, ok := registry.Load(api)
+	if ok {
+		e.(*additionalPropertiesExtension).EvictType(typ)
+	}
+	return ok
+}
+
+// ResetCaches empties the descriptor and binding caches.  The holders
+// counted for lazy codecs are kept, since the API caches their codecs.
+func (e *additionalPropertiesExtension) ResetCaches() {
+	e.Mutex.Lock()
+	defer e.Mutex.Unlock()
+	e.Desc = map[string]*jsoniter.StructDescriptor{}
+	e.Described = map[string]bool{}
+	e.APBinding = map[string]*jsoniter.Binding{}
+	e.Decoders = map[string]jsoniter.ValDecoder{}
+	e.Encoders = map[string]jsoniter.ValEncoder{}
+	e.Errors = map[string]error{}
+	e.Warnings = map[string]error{}
+	e.Plain.Range(func(k, _ interface{}) bool {
+		e.Plain.Delete(k)
+		return true
+	})
+}
+
+// EvictType removes the cached descriptor and binding for typ.
+func (e *additionalPropertiesExtension) EvictType(typ reflect.Type) {
+	name := typeName(reflect2.Type2(typ))
+	e.Mutex.Lock()
+	defer e.Mutex.Unlock()
+	delete(e.Desc, name)
+	delete(e.Described, name)
+	delete(e.APBinding, name)
+	delete(e.Decoders, name)
+	delete(e.Encoders, name)
+	delete(e.Errors, name)
+	delete(e.Warnings, name)
+	e.Plain.Delete(typ)
+}
+
 // ConfigCompatibleWithStandardLibrary provides a jsoniter API object
 // that has already registered the additional-properties extension.
 var ConfigCompatibleWithStandardLibrary = //nolint:gochecknoglobals
@@ -43,35 +218,126 @@ RegisterAdditionalPropertiesExtension(jsoniter.Config{
 	EscapeHTML:             true,
 	SortMapKeys:            true,
 	ValidateJsonRawMessage: true,
-}.Froze())
+}.Froze(), WithStandardFloats())
 
 // UpdateStructDescriptor removes the wildcard field (if it exists) from
 // the fields provided by the StructDescriptor and caches both the
 // resulting field list and the AP field for decorator construction.
+//
+// A struct with more than one AP field, or with an AP map whose values
+// can't be encoded, is recorded as misconfigured and fails when it's
+// marshaled or unmarshaled.
 func (e *additionalPropertiesExtension) UpdateStructDescriptor(desc *jsoniter.StructDescriptor) {
-	log.Debug("UpdateStructDescriptor")
+	e.Options.Log.Debug("UpdateStructDescriptor")
 
 	typ := typeName(desc.Type)
-	log.Debug("Type: ", typ)
+	e.Options.Log.Debug("Type: ", typ)
 
-	e.Mutex.Lock()
-	defer e.Mutex.Unlock()
-	if _, ok := e.Desc[typ]; ok {
-		log.Debug("Short-circuit: Descriptor already updated")
+	// The sample type is described by the self-check itself, which would
+	// deadlock if this extension were also registered globally.
+	if desc.Type == bindingSampleType {
+		return
+	}
+	if err := SelfCheck(); err != nil {
+		// Structs which may have an AP field then fail to be marshaled and
+		// unmarshaled, rather than silently losing additional properties.
+		if len(e.claimedTypes(desc.Type.Type1(), map[reflect.Type]bool{})) > 0 {
+			e.Mutex.Lock()
+			if e.Errors[typ] == nil {
+				e.Errors[typ] = fmt.Errorf("%s: %w", typ, err)
+				e.Options.Log.Error("Additional properties extension disabled: ", e.Errors[typ])
+			}
+			e.Mutex.Unlock()
+		}
 		return
 	}
 
+	e.Mutex.Lock()
+	defer e.Mutex.Unlock()
+	// jsoniter describes a type afresh each time it's used, including as
+	// an embedded struct, so the wildcard field is removed from every
+	// descriptor and the latest one is cached.  A descriptor describing
+	// an embedded struct has its bindings rewritten for the embedding
+	// struct, but it's always superseded by the type's own description
+	// before the type is decorated, which the claim on the type ensures
+	// for other goroutines too (see claim).  Whether the type has been
+	// described since it was last decorated is recorded too (see
+	// customEncoding).
 	e.Desc[typ] = desc
+	e.Described[typ] = true
+	delete(e.APBinding, typ)
+	delete(e.Errors, typ)
+	delete(e.Warnings, typ)
+
+	marker := e.Options.marker(typ)
+	e.Options.Log.Debug("Fields: ", desc.Fields)
+	var wildcards []*jsoniter.Binding
+	var unexported []string
+	fields := make([]*jsoniter.Binding, 0, len(desc.Fields))
+	for _, binding := range desc.Fields {
+		if isUnexportedWildcard(binding, marker) {
+			unexported = append(unexported, binding.Field.Name())
+			fields = append(fields, binding)
+			continue
+		}
+		if binding.Field.Anonymous() && binding.Field.Type() == additionalPropertiesType {
+			wildcards = append(wildcards, binding)
+			e.Options.Log.Debug("    Embedded AP binding: ", binding)
+			continue
+		}
+		if isWildcard(binding, marker) {
+			if !isAPMapType(binding.Field.Type()) {
+				e.Options.Log.Warn("Ignoring wildcard field - not a map with string keys: ", binding.Field.Name())
+				e.Warnings[typ] = fmt.Errorf("%w: %s of %s", ErrWildcardNotMap, binding.Field.Name(), typ)
+				fields = append(fields, binding)
+				continue
+			}
+			wildcards = append(wildcards, binding)
+			e.Options.Log.Debug("    AP binding: ", binding)
+			continue
+		}
+		e.Options.Log.Debug("    Field binding: ", binding)
+		fields = append(fields, binding)
+	}
+	desc.Fields = fields
+	if len(unexported) > 0 {
+		err := fmt.Errorf("%w: %s of %s", ErrUnexportedWildcard, strings.Join(unexported, ", "), typ)
+		e.Options.Log.Error(err)
+		e.Errors[typ] = err
+		return
+	}
 
-	log.Debug("Fields: ", desc.Fields)
-	for idx, binding := range desc.Fields {
-		if len(binding.FromNames) == 1 && binding.FromNames[0] == "*" {
-			e.APBinding[typ] = binding
-			desc.Fields = append(desc.Fields[:idx], desc.Fields[idx+1:]...)
-			log.Debug("    AP binding: ", binding)
+	// An AP field declared by the struct itself shadows those of its
+	// embedded structs, but two at the same depth are ambiguous.
+	if len(wildcards) == 0 {
+		wildcards = e.embeddedAPBindings(desc.Type)
+	}
+	switch len(wildcards) {
+	case 0:
+	case 1:
+		if elem := wildcards[0].Field.Type().(reflect2.MapType).Elem(); !isSupported(elem) {
+			err := fmt.Errorf("%w: %s of field %s of %s", ErrUnsupportedAPType, elem, wildcards[0].Field.Name(), typ)
+			e.Options.Log.Error(err)
+			e.Errors[typ] = err
 			break
 		}
-		log.Debug("    Field binding: ", binding)
+		if field := e.Options.InvalidFields[typ]; field != "" {
+			if f := desc.Type.(reflect2.StructType).FieldByName(field); f == nil || f.Type().Type1() != rawMapType {
+				err := fmt.Errorf("%w: %s of %s isn't a map[string]json.RawMessage field", ErrInvalidValueField, field, typ)
+				e.Options.Log.Error(err)
+				e.Errors[typ] = err
+				break
+			}
+		}
+		e.APBinding[typ] = wildcards[0]
+	default:
+		names := make([]string, 0, len(wildcards))
+		for _, binding := range wildcards {
+			names = append(names, binding.Field.Name())
+		}
+		err := fmt.Errorf("%w: %s has %s", ErrMultipleWildcards, typ, strings.Join(names, ", "))
+		e.Options.Log.Error(err)
+		e.Errors[typ] = err
 	}
 }
 
@@ -79,136 +345,612 @@ func (e *additionalPropertiesExtension) DecorateDecoder(
 	typ reflect2.Type,
 	decoder jsoniter.ValDecoder,
 ) jsoniter.ValDecoder {
-	log.Trace("DecorateDecoder")
+	e.Options.Log.Trace("DecorateDecoder")
+	if _, ok := e.Plain.Load(typ.Type1()); ok {
+		return decoder
+	}
 	name := typeName(typ)
-	log.Debug("Type: ", name)
+	e.Options.Log.Debug("Type: ", name)
 
 	if typ.Kind() != reflect.Struct {
-		log.Debug("Not decorating encoder - not a struct: ", name)
+		e.Options.Log.Debug("Not decorating encoder - not a struct: ", name)
 		return decoder
 	}
 
 	e.Mutex.Lock()
 	defer e.Mutex.Unlock()
-	if e.APBinding[name] == nil && e.Desc[name] != nil {
-		e.APBinding[name] = e.embeddedAPBinding(e.Desc[name].Type)
+	if c, ok := decoder.(*lazyCodec); ok && c.Ext == e {
+		return decoder
+	}
+	claimed := e.release(name, false)
+	// The description is consumed even when it isn't needed, since one
+	// left behind would be mistaken for the type's encoder having been
+	// described.
+	delete(e.Described, name)
+	if customDecoding(typ) {
+		e.Options.Log.Debug("Not decorating decoder - custom unmarshaling: ", name)
+		return decoder
+	}
+	if err := e.Errors[name]; err != nil {
+		return &errorCodec{Err: err}
+	}
+	if d, ok := e.Decoders[name]; ok {
+		e.Options.Log.Debug("Reusing decoder: ", name)
+		return d
 	}
 
+	desc := e.Desc[name]
+	e.Options.Stats.descLookup(desc != nil)
+	// A claimed type that isn't described has a decoder from elsewhere
+	// (e.g. registered with jsoniter), which lazy decoders standing in
+	// for it use too.
+	if desc == nil && claimed {
+		e.Options.Log.Debug("Not decorating decoder - not described: ", name)
+		e.Decoders[name] = decoder
+		return decoder
+	}
+	if desc == nil {
+		e.Options.Log.Debug("Deferring decoder decoration - not described yet: ", name)
+		return &lazyCodec{Ext: e, Name: name, Decoder: decoder}
+	}
+	e.Options.Stats.apBindingLookup(e.APBinding[name] != nil)
 	if e.APBinding[name] == nil {
-		log.Debug("Not decorating encoder - no Additional Properties field")
+		e.Options.Log.Debug("Not decorating encoder - no Additional Properties field")
+		e.markPlain(typ)
+		if claimed {
+			e.Decoders[name] = decoder
+		}
 		return decoder
 	}
 
-	log.Debug("Decorating decoder: ", name)
+	e.Options.Log.Debug("Decorating decoder: ", name)
+	// Other extensions can give a binding several accepted names, and
+	// fields can have names differing only in case (e.g. ID and id), so
+	// the exact names are added last to take precedence over lowercase
+	// ones.  As with jsoniter, the first of several fields with the same
+	// lowercase name keeps the alias.
 	fields := map[string]*jsoniter.Binding{}
-	for _, binding := range e.Desc[name].Fields {
-		fromName := binding.FromNames[0]
-		fields[fromName] = binding
-		fields[strings.ToLower(fromName)] = binding
+	bindings := visibleFields(desc)
+	foldCase := e.Options.foldCase(name)
+	if foldCase {
+		for _, binding := range bindings {
+			for _, fromName := range binding.FromNames {
+				if _, ok := fields[strings.ToLower(fromName)]; !ok {
+					fields[strings.ToLower(fromName)] = binding
+				}
+			}
+		}
+	}
+	for _, binding := range bindings {
+		for _, fromName := range binding.FromNames {
+			fields[fromName] = binding
+		}
+	}
+
+	d := &apStructDecoder{
+		Type:      name,
+		Fields:    fields,
+		FoldCase:  foldCase,
+		APBinding: e.APBinding[name],
+		APMap:     newAPMapType(e.APBinding[name].Field.Type()),
+		Factory:   e.Options.Maps[name],
+		Lenient:   e.Options.Invalid == SkipInvalid,
+		Options:   e.Options,
 	}
+	if field := e.Options.InvalidFields[name]; field != "" {
+		d.Invalid = typ.(reflect2.StructType).FieldByName(field)
+		d.Lenient = true
+	}
+	e.Decoders[name] = d
+	e.Options.Stats.decorated(true)
+	return d
+}
+
+//nolint:gochecknoglobals
+var (
+	marshalerType       = reflect2.TypeOfPtr((*json.Marshaler)(nil)).Elem()
+	unmarshalerType     = reflect2.TypeOfPtr((*json.Unmarshaler)(nil)).Elem()
+	textMarshalerType   = reflect2.TypeOfPtr((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect2.TypeOfPtr((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// customDecoding determines whether jsoniter decodes typ using its
+// UnmarshalJSON (or UnmarshalText) method, in which case collecting any
+// additional properties is up to the method.
+func customDecoding(typ reflect2.Type) bool {
+	ptrType := reflect2.PtrTo(typ)
+	return ptrType.Implements(unmarshalerType) || ptrType.Implements(textUnmarshalerType)
+}
+
+// customEncoding determines whether jsoniter encodes typ using its
+// MarshalJSON (or MarshalText) method.  Like encoding/json, methods with
+// pointer receivers aren't used for the value being marshaled (only for
+// its fields and elements), which jsoniter describes as a struct instead,
+// so described types only use methods with value receivers.
+func customEncoding(typ reflect2.Type, described bool) bool {
+	if typ.Implements(marshalerType) || typ.Implements(textMarshalerType) {
+		return true
+	}
+	ptrType := reflect2.PtrTo(typ)
+	return !described && (ptrType.Implements(marshalerType) || ptrType.Implements(textMarshalerType))
+}
 
-	return &apStructDecoder{fields, e.APBinding[name]}
+// markPlain records that typ, which has been described, has no AP
+// field, so that later decorations skip naming the type, locking and
+// cache lookups.
+func (e *additionalPropertiesExtension) markPlain(typ reflect2.Type) {
+	e.Plain.Store(typ.Type1(), true)
 }
 
+// apStructDecoder decodes the named fields of a struct and collects any
+// remaining keys into the struct's AP map.  Named fields are decoded
+// eagerly while raw AP values are only checked for syntax (see
+// Unmarshal).
+//
+// The AP map can't be pooled since ownership passes to the decoded
+// struct, so instead each map is presized using the number of additional
+// properties seen by the previous decode of the same type.
 type apStructDecoder struct {
+	Type      string
 	Fields    map[string]*jsoniter.Binding
+	FoldCase  bool
 	APBinding *jsoniter.Binding
+	APMap     apMapType
+	Factory   func() interface{}
+	Lenient   bool
+	Invalid   reflect2.StructField
+	Options   *options
+	SizeHint  int64
 }
 
 func (d *apStructDecoder) Decode(ptr unsafe.Pointer, iter *jsoniter.Iterator) {
-	log.Trace("apStructDecoder")
-	ap := map[string]json.RawMessage{}
-	if d.APBinding != nil {
-		d.APBinding.Field.UnsafeSet(ptr, unsafe.Pointer(&ap))
+	d.Options.Log.Trace("apStructDecoder")
+	hint := atomic.LoadInt64(&d.SizeHint)
+	_, suppressed := iter.Attachment.(withoutAP)
+	var ap map[string]json.RawMessage
+	var mapPtr unsafe.Pointer
+	switch {
+	case suppressed, d.Options.Stream != nil:
+	case d.Factory != nil:
+		made := d.Factory()
+		if got := reflect.TypeOf(made); got != d.APMap.Type.Type1() {
+			iter.Error = fmt.Errorf("%w: factory of %s returns %v rather than %s", ErrUnsupportedAPType, d.Type, got, d.APMap.Type)
+			return
+		}
+		// Maps are pointer-shaped, so the interface holds the map itself.
+		m := reflect2.PtrOf(made)
+		if m == nil {
+			mapPtr = d.APMap.Type.UnsafeMakeMap(int(hint))
+			break
+		}
+		mapPtr = unsafe.Pointer(&m)
+	case d.APMap.Raw:
+		ap = make(map[string]json.RawMessage, hint)
+		mapPtr = unsafe.Pointer(&ap)
+	default:
+		mapPtr = d.APMap.Type.UnsafeMakeMap(int(hint))
+	}
+	if d.APMap.Raw && mapPtr != nil {
+		ap = *(*map[string]json.RawMessage)(mapPtr)
+	}
+	// The AP field of a struct embedded as a nil pointer is only set once
+	// the struct is allocated, so that decoding no AP entries leaves it
+	// nil.
+	absent := false
+	if f, ok := d.APBinding.Field.(*promotedField); ok && mapPtr != nil {
+		absent = f.absent(ptr)
+	}
+	if mapPtr != nil && !absent {
+		d.APBinding.Field.UnsafeSet(ptr, mapPtr)
+	}
+
+	var prefixCounts map[string]int
+	if len(d.Options.Prefixes) > 0 {
+		prefixCounts = map[string]int{}
 	}
 
+	var keys []string
+	var invalid map[string]json.RawMessage
+	var scratch []byte
+	var count, skipped int64
 	for {
 		key := iter.ReadObject()
 		if key == "" {
 			break
 		}
+		if d.Options.PreKey != nil {
+			key = d.Options.PreKey(key)
+		}
 
 		binding := d.Fields[key]
 		if binding != nil {
-			log.Debug("Case-sensitive binding: ", binding)
+			d.Options.Log.Debug("Case-sensitive binding: ", binding)
 			binding.Decoder.Decode(ptr, iter)
+			if iter.Error != nil {
+				d.annotateError(iter, "field", key)
+				break
+			}
 			continue
 		}
 
-		// TODO: how do we gete the configuration value for case-sensitivity?
-		binding = d.Fields[strings.ToLower(key)]
+		if d.FoldCase {
+			binding = d.Fields[strings.ToLower(key)]
+		}
 		if binding != nil {
-			log.Debug("Case-insensitive binding: ", binding)
+			d.Options.Log.Debug("Case-insensitive binding: ", binding)
 			binding.Decoder.Decode(ptr, iter)
+			if iter.Error != nil {
+				d.annotateError(iter, "field", key)
+				break
+			}
+			continue
+		}
+
+		if suppressed || d.Options.Ignored[d.Type][key] {
+			d.Options.Log.Debug("Ignored key: ", key)
+			iter.Skip()
+			continue
+		}
+
+		if d.Options.Limit > 0 && count >= int64(d.Options.Limit) {
+			if d.Options.LimitBehavior == RejectExcess {
+				if iter.Error == nil {
+					iter.Error = fmt.Errorf("%w: %s has more than %d", ErrTooManyProperties, d.Type, d.Options.Limit)
+				}
+				return
+			}
+			d.Options.Log.Debug("Skipping excess key: ", key)
+			iter.Skip()
+			skipped++
 			continue
 		}
 
-		var val json.RawMessage
+		if d.Options.APKey != nil {
+			key = d.Options.APKey(key)
+		}
+		switch {
+		case d.Options.Stream != nil:
+			d.Options.Stream(key, iter)
+			d.annotateError(iter, "additional property", key)
+		case d.APMap.Raw:
+			ap[key] = d.readRaw(iter, &scratch)
+			d.annotateError(iter, "additional property", key)
+		default:
+			elem := d.APMap.Elem.New()
+			if raw, ok := d.readElem(iter, elem); !ok && iter.Error == nil {
+				d.Options.Log.Debug("Skipping invalid AP value - key: ", key)
+				if d.Invalid != nil {
+					if invalid == nil {
+						invalid = map[string]json.RawMessage{}
+					}
+					invalid[key] = raw
+				}
+				continue
+			}
+			if iter.Error != nil {
+				d.annotateError(iter, "additional property", key)
+				break
+			}
+			d.Options.Log.Debug("AP value: ", elem)
+			d.APMap.Type.UnsafeSetIndex(mapPtr, unsafe.Pointer(&key), reflect2.PtrOf(elem))
+		}
+		// Reading on from a failed iterator can only obscure the error.
+		if iter.Error != nil {
+			break
+		}
+		count++
+		if prefixCounts != nil {
+			d.Options.countPrefix(prefixCounts, key)
+		}
+		if d.Options.Keys {
+			keys = append(keys, key)
+		}
+	}
+
+	if suppressed {
+		return
+	}
+	if absent && (count > 0 || !d.APBinding.Field.(*promotedField).absent(ptr)) {
+		d.APBinding.Field.UnsafeSet(ptr, mapPtr)
+	}
+	if d.Invalid != nil {
+		d.Invalid.UnsafeSet(ptr, unsafe.Pointer(&invalid))
+	}
+
+	if d.Options.OnDecode != nil {
+		d.Options.OnDecode(DecodeStats{
+			Type:                 d.Type,
+			AdditionalProperties: int(count),
+			PrefixCounts:         prefixCounts,
+			Keys:                 keys,
+		})
+	}
+
+	// The threshold counts the keys skipped by the limit too, since
+	// they're just as unexpected.
+	if t := d.Options.Threshold; t > 0 && count+skipped > int64(t) && iter.Error == nil {
+		iter.Error = fmt.Errorf("%w: %s has %d, more than %d", ErrThresholdExceeded, d.Type, count+skipped, t)
+	}
+
+	d.Options.Stats.captured(count)
+	if count != hint {
+		atomic.StoreInt64(&d.SizeHint, count)
+	}
+}
+
+// annotateError adds the JSON key and struct type to an error reported
+// while decoding a value, so the source of the error can be found in
+// malformed payloads.
+func (d *apStructDecoder) annotateError(iter *jsoniter.Iterator, kind string, key string) {
+	if iter.Error == nil || iter.Error == io.EOF {
+		return
+	}
+	iter.Error = fmt.Errorf("error decoding %s %q of %s: %w", kind, key, d.Type, iter.Error)
+}
+
+// readRaw reads the next value as a json.RawMessage, applying the
+// interning and absent marker options.
+func (d *apStructDecoder) readRaw(iter *jsoniter.Iterator, scratch *[]byte) json.RawMessage {
+	var val json.RawMessage
+	switch {
+	case iter.WhatIsNext() == jsoniter.NumberValue:
+		// Skipping a number parses it, which rejects valid JSON numbers
+		// that overflow a float64, so numbers are read verbatim instead.
+		val = readNumber(iter)
+		if d.Options.Values != nil && val != nil {
+			val = d.Options.Values.intern(val)
+		}
+	case d.Options.Values == nil:
 		iter.ReadVal(&val)
-		log.Debug("AP value: ", val)
-		ap[key] = val
+	case !iter.ReadNil():
+		// SkipAndAppendBytes requires a non-nil buffer
+		if *scratch == nil {
+			*scratch = make([]byte, 0, 64)
+		}
+		*scratch = iter.SkipAndAppendBytes((*scratch)[:0])
+		val = d.Options.Values.intern(*scratch)
+	}
+	if d.Options.Absent[string(val)] {
+		val = nil
+	}
+	d.Options.Log.Debug("AP value: ", val)
+	return val
+}
+
+// readElem decodes the next value into elem, leaving it as the zero
+// value if it's one of the absent markers.  Only strings can be markers,
+// so only they're read ahead.  Unsuitable values fail the decode unless
+// the decoder is lenient, in which case they're reported as by
+// readLenient.
+func (d *apStructDecoder) readElem(iter *jsoniter.Iterator, elem interface{}) (json.RawMessage, bool) {
+	if len(d.Options.Absent) == 0 || iter.WhatIsNext() != jsoniter.StringValue {
+		if d.Lenient {
+			return d.readLenient(iter, elem)
+		}
+		iter.ReadVal(elem)
+		return nil, true
+	}
+	raw := iter.SkipAndReturnBytes()
+	if iter.Error != nil || d.Options.Absent[string(raw)] {
+		return nil, true
+	}
+	sub := iter.Pool().BorrowIterator(raw)
+	defer iter.Pool().ReturnIterator(sub)
+	sub.Attachment = iter.Attachment
+	if d.Lenient {
+		return d.readLenient(sub, elem)
+	}
+	sub.ReadVal(elem)
+	if sub.Error != nil && sub.Error != io.EOF {
+		iter.Error = sub.Error
+	}
+	return nil, true
+}
+
+// readLenient decodes the next value into elem, returning false (and the
+// value's raw JSON) rather than failing the decode if the value doesn't
+// suit elem's type.  Invalid JSON still fails the decode.
+func (d *apStructDecoder) readLenient(iter *jsoniter.Iterator, elem interface{}) (json.RawMessage, bool) {
+	var raw json.RawMessage
+	if iter.WhatIsNext() == jsoniter.NumberValue {
+		raw = readNumber(iter)
+	} else {
+		raw = append(raw, iter.SkipAndReturnBytes()...)
+	}
+	if iter.Error != nil {
+		return nil, false
+	}
+	sub := iter.Pool().BorrowIterator(raw)
+	defer iter.Pool().ReturnIterator(sub)
+	sub.Attachment = iter.Attachment
+	sub.ReadVal(elem)
+	// A value only partly read (e.g. 1e3 read as an int) is invalid too.
+	if sub.Error == nil {
+		sub.WhatIsNext()
 	}
+	return raw, sub.Error == io.EOF
+}
+
+// readNumber returns the next number exactly as it appears in the JSON.
+func readNumber(iter *jsoniter.Iterator) json.RawMessage {
+	num := json.RawMessage(iter.ReadNumber())
+	if !json.Valid(num) {
+		iter.ReportError("readNumber", "invalid number: "+string(num))
+		return nil
+	}
+	return num
 }
 
 func (e *additionalPropertiesExtension) DecorateEncoder(
 	typ reflect2.Type,
 	encoder jsoniter.ValEncoder,
 ) jsoniter.ValEncoder {
-	log.Trace("DecorateEncoder")
+	e.Options.Log.Trace("DecorateEncoder")
+	if _, ok := e.Plain.Load(typ.Type1()); ok {
+		return encoder
+	}
 	name := typeName(typ)
-	log.Debug("Type: ", name)
+	e.Options.Log.Debug("Type: ", name)
 
 	if typ.Kind() != reflect.Struct {
-		log.Debug("Not decorating encoder - not a struct: ", name)
+		e.Options.Log.Debug("Not decorating encoder - not a struct: ", name)
 		return encoder
 	}
 
 	e.Mutex.Lock()
 	defer e.Mutex.Unlock()
-	if e.APBinding[name] == nil && e.Desc[name] != nil {
-		e.APBinding[name] = e.embeddedAPBinding(e.Desc[name].Type)
+	if c, ok := encoder.(*lazyCodec); ok && c.Ext == e {
+		return encoder
+	}
+	claimed := e.release(name, true)
+	described := e.Described[name]
+	delete(e.Described, name)
+	if customEncoding(typ, described) {
+		e.Options.Log.Debug("Not decorating encoder - custom marshaling: ", name)
+		return encoder
+	}
+	if err := e.Errors[name]; err != nil {
+		return &errorCodec{Err: err}
+	}
+	if enc, ok := e.Encoders[name]; ok {
+		e.Options.Log.Debug("Reusing encoder: ", name)
+		return enc
 	}
 
+	desc := e.Desc[name]
+	e.Options.Stats.descLookup(desc != nil)
+	if desc == nil && claimed {
+		e.Options.Log.Debug("Not decorating encoder - not described: ", name)
+		e.Encoders[name] = encoder
+		return encoder
+	}
+	if desc == nil {
+		e.Options.Log.Debug("Deferring encoder decoration - not described yet: ", name)
+		return &lazyCodec{Ext: e, Name: name, Encoder: encoder}
+	}
 	apBinding, ok := e.APBinding[name]
+	e.Options.Stats.apBindingLookup(apBinding != nil)
 	if !ok {
-		log.Debug("Not decorating encoder - no AP field")
+		e.Options.Log.Debug("Not decorating encoder - no AP field")
+		e.markPlain(typ)
+		if claimed {
+			e.Encoders[name] = encoder
+		}
 		return encoder
 	}
 
 	if apBinding == nil {
-		log.Debug("Not decorating encoder - AP binding is nil")
+		e.Options.Log.Debug("Not decorating encoder - AP binding is nil")
 		return encoder
 	}
 
-	log.Debug("Decorating encoder: ", name)
+	e.Options.Log.Debug("Decorating encoder: ", name)
 	fields := map[string]*jsoniter.Binding{}
-	for _, binding := range e.Desc[name].Fields {
+	bindings := visibleFields(desc)
+	order := make([]string, 0, len(bindings))
+	// The omitempty qualifier is keyed by the encoded name, which other
+	// extensions (e.g. naming strategies) may have changed from the tag.
+	omitEmpties := map[string]bool{}
+	for _, binding := range bindings {
 		toName := binding.ToNames[0]
 		fields[toName] = binding
+		order = append(order, toName)
+		_, qualifiers := jsonTag(binding.Field)
+		omitEmpties[toName] = qualifiers["omitempty"]
 	}
 
-	styp := typ.(reflect2.StructType)
-	omitEmpties := omitEmpties(styp)
-	return &apStructEncoder{fields, apBinding, omitEmpties}
+	enc := &apStructEncoder{
+		Fields:      fields,
+		Order:       order,
+		APBinding:   apBinding,
+		APMap:       newAPMapType(apBinding.Field.Type()),
+		OmitEmpties: omitEmpties,
+		Options:     e.Options,
+	}
+	e.Encoders[name] = enc
+	e.Options.Stats.decorated(false)
+	return enc
 }
 
-func omitEmpties(typ reflect2.StructType) map[string]bool {
-	empties := map[string]bool{}
-	for i := 0; i < typ.NumField(); i++ {
-		f := typ.Field(i)
-		if f.Anonymous() {
-			styp := f.Type().(reflect2.StructType)
-			embeddedEmpties := omitEmpties(styp)
-			for k := range embeddedEmpties {
-				empties[k] = embeddedEmpties[k]
-			}
+// visibleFields returns the bindings of desc which aren't shadowed by a
+// shallower binding with the same encoded name, in their original
+// order.  The descriptor holds the bindings of embedded structs
+// alongside those of the struct itself, and jsoniter only resolves the
+// conflicts when building its own codecs.
+func visibleFields(desc *jsoniter.StructDescriptor) []*jsoniter.Binding {
+	depths := fieldDepths(desc)
+	shallowest := map[string]*jsoniter.Binding{}
+	for _, binding := range desc.Fields {
+		// Fields tagged "-" have no names.
+		if len(binding.ToNames) == 0 {
 			continue
 		}
-		name, qualifiers := jsonTag(f)
-		log.Debug("Field name: ", name, ", qualifiers: ", qualifiers)
-		empties[name] = qualifiers["omitempty"]
+		name := binding.ToNames[0]
+		if b, ok := shallowest[name]; !ok || depths[binding] < depths[b] {
+			shallowest[name] = binding
+		}
+	}
+	visible := make([]*jsoniter.Binding, 0, len(shallowest))
+	for _, binding := range desc.Fields {
+		if len(binding.ToNames) > 0 && shallowest[binding.ToNames[0]] == binding {
+			visible = append(visible, binding)
+		}
+	}
+	return visible
+}
+
+// fieldKey identifies a field within the struct declaring it.
+type fieldKey struct {
+	Name    string
+	PkgPath string
+	Type    reflect.Type
+	Tag     reflect.StructTag
+	Offset  uintptr
+}
+
+// fieldDepths returns the embedding depth of each binding of desc.  A
+// binding only holds the field of the struct declaring it, so the depth
+// is recovered from the index paths of the fields reached through the
+// embedded structs (see embeddedStructs).  jsoniter sorts the bindings
+// by index path, so when the same field is reached along several paths
+// (e.g. through two structs embedding the same type), the bindings of
+// the field match the paths in order.
+func fieldDepths(desc *jsoniter.StructDescriptor) map[*jsoniter.Binding]int {
+	paths := map[fieldKey][]int{}
+	var walk func(typ reflect.Type, depth int, seen map[reflect.Type]bool)
+	walk = func(typ reflect.Type, depth int, seen map[reflect.Type]bool) {
+		seen[typ] = true
+		defer delete(seen, typ)
+		embedded := embeddedStructs(typ)
+		for i := 0; i < typ.NumField(); i++ {
+			f := typ.Field(i)
+			if len(embedded) > 0 && embedded[0].Index == i {
+				elem := f.Type
+				if embedded[0].Ptr {
+					elem = elem.Elem()
+				}
+				embedded = embedded[1:]
+				if !seen[elem] {
+					walk(elem, depth+1, seen)
+				}
+				continue
+			}
+			key := fieldKey{Name: f.Name, PkgPath: f.PkgPath, Type: f.Type, Tag: f.Tag, Offset: f.Offset}
+			paths[key] = append(paths[key], depth)
+		}
+	}
+	walk(desc.Type.Type1(), 0, map[reflect.Type]bool{})
+
+	depths := make(map[*jsoniter.Binding]int, len(desc.Fields))
+	for _, binding := range desc.Fields {
+		f := binding.Field
+		key := fieldKey{Name: f.Name(), PkgPath: f.PkgPath(), Type: f.Type().Type1(), Tag: f.Tag(), Offset: f.Offset()}
+		if matched := paths[key]; len(matched) > 0 {
+			depths[binding] = matched[0]
+			paths[key] = matched[1:]
+		}
 	}
-	return empties
+	return depths
 }
 
 func jsonTag(f reflect2.StructField) (string, map[string]bool) {
@@ -230,74 +972,450 @@ func jsonTag(f reflect2.StructField) (string, map[string]bool) {
 
 type apStructEncoder struct {
 	Fields      map[string]*jsoniter.Binding
+	Order       []string
 	APBinding   *jsoniter.Binding
+	APMap       apMapType
 	OmitEmpties map[string]bool
+	Options     *options
 }
 
 func (e *apStructEncoder) Encode(ptr unsafe.Pointer, stream *jsoniter.Stream) {
-	log.Debug("apStructEncoder")
+	e.Options.Log.Debug("apStructEncoder")
 
-	log.Debugf("Stream: %v", ptr)
+	e.Options.Log.Debug("Stream: ", ptr)
 	stream.WriteObjectStart()
-	log.Debug("Field count: ", len(e.Fields))
+	e.Options.Log.Debug("Field count: ", len(e.Fields))
 
 	first := true
-	for key, binding := range e.Fields {
-		log.Debug("Field key: ", key)
-		if e.OmitEmpties[key] && binding.Encoder.IsEmpty(ptr) {
-			log.Debug("Omitempty - key: ", key)
-			continue
+	if e.Options.FieldOrder {
+		for _, key := range e.Order {
+			first = e.encodeField(ptr, stream, key, e.Fields[key], first)
 		}
-		if !first {
-			stream.WriteMore()
+	} else {
+		for key, binding := range e.Fields {
+			first = e.encodeField(ptr, stream, key, binding, first)
 		}
-		stream.WriteObjectField(key)
-		binding.Encoder.Encode(ptr, stream)
-		first = false
 	}
 
-	log.Debug("AP binding: ", e.APBinding)
-	if e.APBinding == nil {
+	if _, suppressed := stream.Attachment.(withoutAP); suppressed {
 		stream.WriteObjectEnd()
 		return
 	}
 
-	// Add the additional properties to the
+	// Encoders are only built for structs with an AP binding.
+	e.Options.Log.Debug("AP binding: ", e.APBinding)
+	if !e.APMap.Raw {
+		e.encodeTyped(ptr, stream, first)
+		stream.WriteObjectEnd()
+		return
+	}
 	ap := *(*map[string]json.RawMessage)(e.APBinding.Field.UnsafeGet(ptr))
-	log.Debug("AP: ", ap)
-	for k, v := range ap {
-		log.Debug("K: ", k, ", V: ", v)
-		if !first {
-			stream.WriteMore()
+	e.Options.Log.Debug("AP: ", ap)
+	if e.Options.SortAP {
+		for _, k := range sortedKeys(ap) {
+			first = e.encodeRaw(stream, k, ap[k], e.APMap.RawMessage, first)
+		}
+	} else {
+		for k, v := range ap {
+			first = e.encodeRaw(stream, k, v, e.APMap.RawMessage, first)
 		}
-		stream.WriteObjectField(k)
-		stream.WriteVal(v)
-		first = false
 	}
 	stream.WriteObjectEnd()
 }
 
+// encodeField writes a named field unless it's omitted, returning whether
+// the next value is still the object's first.
+func (e *apStructEncoder) encodeField(
+	ptr unsafe.Pointer,
+	stream *jsoniter.Stream,
+	key string,
+	binding *jsoniter.Binding,
+	first bool,
+) bool {
+	e.Options.Log.Debug("Field key: ", key)
+	if e.OmitEmpties[key] && binding.Encoder.IsEmpty(ptr) {
+		e.Options.Log.Debug("Omitempty - key: ", key)
+		return first
+	}
+	// As with jsoniter, the fields of nil embedded structs are omitted.
+	if enc, ok := binding.Encoder.(jsoniter.IsEmbeddedPtrNil); ok && enc.IsEmbeddedPtrNil(ptr) {
+		return first
+	}
+	if !first {
+		stream.WriteMore()
+	}
+	stream.WriteObjectField(key)
+	binding.Encoder.Encode(ptr, stream)
+	return false
+}
+
+// encodeAP writes an additional property, returning whether the next
+// value is still the object's first.
+func (e *apStructEncoder) encodeAP(stream *jsoniter.Stream, key string, val interface{}, first bool) bool {
+	if raw, ok := val.(json.RawMessage); ok {
+		return e.encodeRaw(stream, key, raw, true, first)
+	}
+	e.Options.Log.Debug("K: ", key, ", V: ", val)
+	if e.Options.omitKey(key) {
+		e.Options.Log.Debug("Omitted AP key: ", key)
+		return first
+	}
+	if !first {
+		stream.WriteMore()
+	}
+	stream.WriteObjectField(key)
+	stream.WriteVal(val)
+	return false
+}
+
+// encodeRaw writes an additional property held as raw JSON, returning
+// whether the next value is still the object's first.  Only values held
+// as a json.RawMessage (rather than another raw type) can be passed
+// straight through.
+func (e *apStructEncoder) encodeRaw(stream *jsoniter.Stream, key string, raw json.RawMessage, passthrough, first bool) bool {
+	e.Options.Log.Debug("K: ", key, ", V: ", raw)
+	if e.Options.omitKey(key) || (e.Options.OmitNull && isNull(raw)) {
+		e.Options.Log.Debug("Omitted AP key: ", key)
+		return first
+	}
+	if e.Options.Omit != nil && e.Options.Omit(key, raw) {
+		e.Options.Log.Debug("Omitted AP key: ", key)
+		return first
+	}
+	if !first {
+		stream.WriteMore()
+	}
+	stream.WriteObjectField(key)
+	if api, ok := stream.Pool().(jsoniter.API); ok && e.Options.Canonical {
+		if val, ok := decodeGeneric(api, raw); ok {
+			stream.WriteVal(val)
+			return false
+		}
+	}
+	// Without ValidateJsonRawMessage or HTML escaping, the API's
+	// json.RawMessage encoder writes the bytes verbatim (and nil as null),
+	// so they're passed straight through instead.
+	if passthrough && !e.Options.ValidateRaw && !e.Options.EscapeHTML {
+		if raw == nil {
+			stream.WriteNil()
+		} else {
+			stream.WriteRaw(string(raw))
+		}
+		return false
+	}
+	// Raw values are written by the API's json.RawMessage encoder, which
+	// replaces invalid JSON with null when ValidateJsonRawMessage is set.
+	// Its validation also rejects numbers that overflow a float64, so
+	// numbers are checked and written verbatim instead.
+	if isNumber(raw) {
+		stream.WriteRaw(string(raw))
+		return false
+	}
+	// Like encoding/json, raw values are HTML-escaped when the API escapes
+	// the strings of named fields, and invalid values are left to the
+	// json.RawMessage encoder.
+	if e.Options.EscapeHTML && needsHTMLEscape(raw) && json.Valid(raw) {
+		var buf bytes.Buffer
+		json.HTMLEscape(&buf, raw)
+		stream.WriteRaw(buf.String())
+		return false
+	}
+	stream.WriteVal(raw)
+	return false
+}
+
+// decodeGeneric decodes raw into a generic value using api, with numbers
+// as json.Number, returning false if it isn't valid JSON.  jsoniter
+// stops decoding after the value and accepts malformed numbers such as
+// 01 or 1., so both are checked afterwards.
+func decodeGeneric(api jsoniter.API, raw json.RawMessage) (interface{}, bool) {
+	r := bytes.NewReader(raw)
+	dec := api.NewDecoder(r)
+	dec.UseNumber()
+	var val interface{}
+	if err := dec.Decode(&val); err != nil {
+		return nil, false
+	}
+	rest, err := io.ReadAll(io.MultiReader(dec.Buffered(), r))
+	if err != nil || len(bytes.Trim(rest, " \t\r\n")) > 0 {
+		return nil, false
+	}
+	return val, validNumbers(val)
+}
+
+// validNumbers determines whether every json.Number within the generic
+// value val is a valid JSON number.
+func validNumbers(val interface{}) bool {
+	switch v := val.(type) {
+	case json.Number:
+		return isNumber(json.RawMessage(v))
+	case []interface{}:
+		for _, elem := range v {
+			if !validNumbers(elem) {
+				return false
+			}
+		}
+	case map[string]interface{}:
+		for _, elem := range v {
+			if !validNumbers(elem) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// isNull determines whether raw is nil or the JSON literal null,
+// surrounded only by JSON whitespace.
+func isNull(raw json.RawMessage) bool {
+	return raw == nil || string(bytes.Trim(raw, " \t\r\n")) == "null"
+}
+
+// needsHTMLEscape determines whether raw contains any of the characters
+// escaped by json.HTMLEscape.
+func needsHTMLEscape(raw json.RawMessage) bool {
+	return bytes.ContainsAny(raw, "<>&\u2028\u2029")
+}
+
+// isNumber determines whether raw is a single valid JSON number.
+func isNumber(raw json.RawMessage) bool {
+	if len(raw) == 0 || (raw[0] != '-' && (raw[0] < '0' || raw[0] > '9')) {
+		return false
+	}
+	return json.Valid(raw)
+}
+
+// encodeTyped writes the entries of a typed AP map using the encoder of
+// the map's value type, so values implementing json.Marshaler (or with
+// custom jsoniter encoders) are honored.
+func (e *apStructEncoder) encodeTyped(ptr unsafe.Pointer, stream *jsoniter.Stream, first bool) {
+	iter := e.APMap.Type.UnsafeIterate(e.APBinding.Field.UnsafeGet(ptr))
+	if !e.Options.SortAP {
+		for iter.HasNext() {
+			k, v := iter.UnsafeNext()
+			first = e.encodeAP(stream, *(*string)(k), e.APMap.Elem.UnsafeIndirect(v), first)
+		}
+		return
+	}
+
+	vals := map[string]unsafe.Pointer{}
+	for iter.HasNext() {
+		k, v := iter.UnsafeNext()
+		vals[*(*string)(k)] = v
+	}
+	keys := make([]string, 0, len(vals))
+	for k := range vals {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		first = e.encodeAP(stream, k, e.APMap.Elem.UnsafeIndirect(vals[k]), first)
+	}
+}
+
+func sortedKeys(ap map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(ap))
+	for k := range ap {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func (e *apStructEncoder) IsEmpty(ptr unsafe.Pointer) bool {
 	return false
 }
 
-func (e *additionalPropertiesExtension) embeddedAPBinding(typ reflect2.Type) *jsoniter.Binding {
+// embeddedAPBindings returns the AP bindings of each struct embedded in
+// typ, with their fields accessed relative to typ.  It only consults
+// the bindings already cached for the embedded structs (jsoniter
+// describes those first, and they're cached transitively) rather than
+// walking their fields, so it terminates for recursive types.
+func (e *additionalPropertiesExtension) embeddedAPBindings(typ reflect2.Type) []*jsoniter.Binding {
 	str, ok := typ.(*reflect2.UnsafeStructType)
 	if !ok {
 		return nil
 	}
-	var ap *jsoniter.Binding
-	for i := 0; i < str.NumField(); i++ {
-		f := str.Field(i)
-		if f.Anonymous() {
-			name := typeName(f.Type())
-			if a, ok := e.APBinding[name]; ok {
-				ap = a
-				break
-			}
+	var bindings []*jsoniter.Binding
+	for _, embedded := range embeddedStructs(typ.Type1()) {
+		f := str.Field(embedded.Index)
+		elem := f.Type()
+		if embedded.Ptr {
+			elem = elem.(*reflect2.UnsafePtrType).Elem()
+		}
+		if a := e.APBinding[typeName(elem)]; a != nil {
+			promoted := *a
+			promoted.Field = &promotedField{StructField: a.Field, Embedded: f}
+			bindings = append(bindings, &promoted)
+		}
+	}
+	return bindings
+}
+
+// embeddedStruct is a struct embedded in another, directly or as a
+// pointer, whose fields are promoted to the embedding struct.
+type embeddedStruct struct {
+	Index int
+	Ptr   bool
+}
+
+// embeddedStructs returns the structs embedded in the struct type typ,
+// in declaration order, following jsoniter's rules: embedded interfaces
+// and other types have no fields to promote, and an embedded struct
+// with a JSON name (or tagged "-") is a named field instead.  Both the
+// extension and AdditionalPropertiesOf locate promoted AP fields using
+// it, so they agree on which structs are walked.
+func embeddedStructs(typ reflect.Type) []embeddedStruct {
+	var embedded []embeddedStruct
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if !f.Anonymous {
+			continue
 		}
+		if tag := f.Tag.Get("json"); tag == "-" || strings.Split(tag, ",")[0] != "" {
+			continue
+		}
+		switch {
+		case f.Type.Kind() == reflect.Struct:
+			embedded = append(embedded, embeddedStruct{Index: i})
+		case f.Type.Kind() == reflect.Ptr && f.Type.Elem().Kind() == reflect.Struct:
+			embedded = append(embedded, embeddedStruct{Index: i, Ptr: true})
+		}
+	}
+	return embedded
+}
+
+// promotedField is the AP field of an embedded struct, accessed through
+// the embedding struct.  Only the unsafe accessors (which are all that
+// the AP codecs use) are redirected.
+//
+// When the struct is embedded as a pointer, like jsoniter, UnsafeSet
+// allocates the struct if the pointer is nil, while UnsafeGet reads a nil
+// map instead.  Offset isn't meaningful in that case.  The decoder only
+// sets the field of a nil embedded struct once it has an entry.
+type promotedField struct {
+	reflect2.StructField
+	Embedded reflect2.StructField
+}
+
+func (f *promotedField) Offset() uintptr {
+	return f.Embedded.Offset() + f.StructField.Offset()
+}
+
+func (f *promotedField) UnsafeGet(obj unsafe.Pointer) unsafe.Pointer {
+	embedded := f.embedded(obj, false)
+	if embedded == nil {
+		return unsafe.Pointer(&nilMap)
+	}
+	return f.StructField.UnsafeGet(embedded)
+}
+
+func (f *promotedField) UnsafeSet(obj unsafe.Pointer, value unsafe.Pointer) {
+	f.StructField.UnsafeSet(f.embedded(obj, true), value)
+}
+
+// absent determines whether the AP field isn't allocated within obj,
+// because it's in a struct embedded as a nil pointer at some level.
+func (f *promotedField) absent(obj unsafe.Pointer) bool {
+	embedded := f.embedded(obj, false)
+	if embedded == nil {
+		return true
+	}
+	if inner, ok := f.StructField.(*promotedField); ok {
+		return inner.absent(embedded)
+	}
+	return false
+}
+
+// embedded returns a pointer to the embedded struct within obj, which is
+// nil if it's embedded as a nil pointer and alloc isn't set.
+func (f *promotedField) embedded(obj unsafe.Pointer, alloc bool) unsafe.Pointer {
+	ptr := f.Embedded.UnsafeGet(obj)
+	ptrType, ok := f.Embedded.Type().(*reflect2.UnsafePtrType)
+	if !ok {
+		return ptr
+	}
+	if *(*unsafe.Pointer)(ptr) == nil && alloc {
+		elem := ptrType.Elem().UnsafeNew()
+		f.Embedded.UnsafeSet(obj, unsafe.Pointer(&elem))
+	}
+	return *(*unsafe.Pointer)(ptr)
+}
+
+// nilMap is read in place of the AP map of a nil embedded struct.  Every
+// map type has the same representation, so it stands in for typed AP
+// maps too, and it's never written.
+//
+//nolint:gochecknoglobals
+var nilMap map[string]json.RawMessage
+
+// isWildcard determines whether the binding's field is marked as the AP
+// field.  Markers starting with a comma are matched against the json tag
+// qualifiers while other markers are matched against the field's name.
+func isWildcard(binding *jsoniter.Binding, marker string) bool {
+	if strings.HasPrefix(marker, ",") {
+		_, qualifiers := jsonTag(binding.Field)
+		return qualifiers[marker[1:]]
+	}
+	return len(binding.FromNames) == 1 && binding.FromNames[0] == marker
+}
+
+// isMarked determines whether the struct field f is marked as the AP
+// field by its json tag, as isWildcard does for bindings.
+func isMarked(f reflect2.StructField, marker string) bool {
+	name, qualifiers := jsonTag(f)
+	if strings.HasPrefix(marker, ",") {
+		return qualifiers[marker[1:]]
+	}
+	return name == marker
+}
+
+// apMapType describes the type of a struct's AP field.  Its keys can have
+// any type of string kind (e.g. a defined type Key string), which share
+// the representation of strings, so keys are read and written as strings
+// through unsafe pointers, and raw maps are accessed as
+// map[string]json.RawMessage.
+type apMapType struct {
+	Type       reflect2.MapType
+	Elem       reflect2.Type
+	Raw        bool
+	RawMessage bool
+}
+
+func newAPMapType(typ reflect2.Type) apMapType {
+	mtyp := typ.(reflect2.MapType)
+	return apMapType{
+		Type:       mtyp,
+		Elem:       mtyp.Elem(),
+		Raw:        isRawMapType(typ),
+		RawMessage: mtyp.Elem().Type1() == rawMapType.Elem(),
+	}
+}
+
+// isAPMapType determines whether the passed type can hold additional
+// properties, which requires a map with keys of string kind.
+func isAPMapType(typ reflect2.Type) bool {
+	return typ.Kind() == reflect.Map && typ.(reflect2.MapType).Key().Kind() == reflect.String
+}
+
+// isRawMapType determines whether the passed AP map holds raw JSON
+// values.  Besides json.RawMessage, a defined slice of bytes which
+// marshals and unmarshals itself (such as a vendored copy of RawMessage)
+// is passed through as raw JSON.  Other slices of bytes, including
+// []byte, are typed values encoded as base64.
+func isRawMapType(typ reflect2.Type) bool {
+	if !isAPMapType(typ) {
+		return false
+	}
+	elem := typ.(reflect2.MapType).Elem()
+	if elem.Type1() == rawMapType.Elem() {
+		return true
+	}
+	if elem.Kind() != reflect.Slice || elem.(reflect2.SliceType).Elem().Kind() != reflect.Uint8 || elem.Type1().Name() == "" {
+		return false
 	}
-	return ap
+	ptrType := reflect2.PtrTo(elem)
+	return ptrType.Implements(marshalerType) && ptrType.Implements(unmarshalerType)
 }
 
 func typeName(typ reflect2.Type) string {
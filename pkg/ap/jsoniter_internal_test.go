@@ -0,0 +1,184 @@
+package ap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/modern-go/reflect2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type cached struct {
+	FieldA string                     `json:"fieldA"`
+	AP     map[string]json.RawMessage `json:"*"`
+}
+
+type other struct {
+	FieldB string                     `json:"fieldB"`
+	AP     map[string]json.RawMessage `json:"*"`
+}
+
+func TestEvictType(t *testing.T) {
+	api := RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze())
+	v, ok := registry.Load(api)
+	require.True(t, ok)
+	e := v.(*additionalPropertiesExtension)
+
+	require.NoError(t, api.Unmarshal([]byte(`{"fieldA":"a"}`), &cached{}))
+	require.NoError(t, api.Unmarshal([]byte(`{"fieldB":"b"}`), &other{}))
+	assert.Contains(t, e.Desc, "ap.cached")
+	assert.Contains(t, e.APBinding, "ap.cached")
+
+	assert.True(t, EvictType(api, reflect.TypeOf(cached{})))
+	assert.NotContains(t, e.Desc, "ap.cached")
+	assert.NotContains(t, e.APBinding, "ap.cached")
+	assert.Contains(t, e.Desc, "ap.other")
+
+	assert.True(t, ResetCaches(api))
+	assert.Empty(t, e.Desc)
+	assert.Empty(t, e.APBinding)
+
+	// The jsoniter.API's own codec cache is unaffected
+	var c cached
+	require.NoError(t, api.Unmarshal([]byte(`{"fieldA":"a","fieldB":"b"}`), &c))
+	assert.Equal(t, `"b"`, string(c.AP["fieldB"]))
+}
+
+func TestUnregister(t *testing.T) {
+	api := RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze())
+	assert.True(t, UnregisterAdditionalPropertiesExtension(api))
+	_, ok := registry.Load(api)
+	assert.False(t, ok)
+	assert.False(t, UnregisterAdditionalPropertiesExtension(api))
+	assert.False(t, ResetCaches(api))
+
+	// The API keeps the extension.
+	var c cached
+	require.NoError(t, api.Unmarshal([]byte(`{"fieldA":"a","fieldB":"b"}`), &c))
+	assert.Equal(t, `"b"`, string(c.AP["fieldB"]))
+}
+
+func TestEvictTypeWithoutExtension(t *testing.T) {
+	api := jsoniter.Config{}.Froze()
+	assert.False(t, ResetCaches(api))
+	assert.False(t, EvictType(api, reflect.TypeOf(cached{})))
+}
+
+func TestDecoratorsAreReused(t *testing.T) {
+	api := RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze())
+	v, ok := registry.Load(api)
+	require.True(t, ok)
+	e := v.(*additionalPropertiesExtension)
+
+	require.NoError(t, api.Unmarshal([]byte(`{"fieldA":"a"}`), &cached{}))
+	_, err := api.Marshal(cached{})
+	require.NoError(t, err)
+
+	typ := reflect2.TypeOf(cached{})
+	dec := e.DecorateDecoder(typ, nil)
+	assert.IsType(t, &apStructDecoder{}, dec)
+	assert.Same(t, dec, e.DecorateDecoder(typ, nil))
+
+	enc := e.DecorateEncoder(typ, nil)
+	assert.IsType(t, &apStructEncoder{}, enc)
+	assert.Same(t, enc, e.DecorateEncoder(typ, nil))
+}
+
+type plain struct {
+	FieldA string `json:"fieldA"`
+}
+
+func TestPlainTypesAreRemembered(t *testing.T) {
+	api := RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze())
+	v, ok := registry.Load(api)
+	require.True(t, ok)
+	e := v.(*additionalPropertiesExtension)
+
+	require.NoError(t, api.Unmarshal([]byte(`{"fieldA":"a"}`), &plain{}))
+	_, ok = e.Plain.Load(reflect.TypeOf(plain{}))
+	assert.True(t, ok)
+
+	assert.True(t, EvictType(api, reflect.TypeOf(plain{})))
+	_, ok = e.Plain.Load(reflect.TypeOf(plain{}))
+	assert.False(t, ok)
+}
+
+func BenchmarkDecoratePlainType(b *testing.B) {
+	api := RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze())
+	v, _ := registry.Load(api)
+	e := v.(*additionalPropertiesExtension)
+	require.NoError(b, api.Unmarshal([]byte(`{"fieldA":"a"}`), &plain{}))
+
+	typ := reflect2.TypeOf(plain{})
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		e.DecorateDecoder(typ, nil)
+		e.DecorateEncoder(typ, nil)
+	}
+}
+
+func TestInternerBounds(t *testing.T) {
+	in := newInterner()
+	val := in.intern([]byte(`"same"`))
+	assert.Equal(t, len(val), cap(val))
+	assert.Same(t, &val[0], &in.intern([]byte(`"same"`))[0])
+
+	for i := 1; i < maxInternedValues; i++ {
+		in.intern([]byte(fmt.Sprint(i)))
+	}
+	assert.Len(t, in.values, maxInternedValues)
+
+	// Once full, values are copied without being interned.
+	full := in.intern([]byte(`"new"`))
+	assert.Equal(t, `"new"`, string(full))
+	assert.NotSame(t, &full[0], &in.intern([]byte(`"new"`))[0])
+	assert.Len(t, in.values, maxInternedValues)
+
+	in = newInterner()
+	in.intern(make([]byte, maxInternedBytes))
+	large := in.intern([]byte(`"new"`))
+	assert.NotSame(t, &large[0], &in.intern([]byte(`"new"`))[0])
+	assert.Equal(t, maxInternedBytes, in.size)
+}
+
+// errorLogger records the errors logged to it.
+type errorLogger struct {
+	Errors []string
+}
+
+func (*errorLogger) Trace(...interface{}) {}
+func (*errorLogger) Debug(...interface{}) {}
+func (*errorLogger) Warn(...interface{})  {}
+func (l *errorLogger) Error(args ...interface{}) {
+	l.Errors = append(l.Errors, fmt.Sprint(args...))
+}
+
+func TestFailedSelfCheck(t *testing.T) {
+	require.NoError(t, SelfCheck())
+	failure := errors.New("jsoniter self-check: broken")
+	selfCheckErr = failure
+	t.Cleanup(func() { selfCheckErr = nil })
+
+	logger := &errorLogger{}
+	api := RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze(), WithLogger(logger))
+	t.Cleanup(func() { UnregisterAdditionalPropertiesExtension(api) })
+
+	// Structs with an AP field fail loudly, through the extension's logger.
+	err := api.Unmarshal([]byte(`{"fieldA":"a","fieldB":"b"}`), &cached{})
+	assert.ErrorIs(t, err, failure)
+	_, err = api.Marshal(cached{FieldA: "a"})
+	assert.ErrorIs(t, err, failure)
+	assert.Equal(t, []string{"Additional properties extension disabled: ap.cached: jsoniter self-check: broken"}, logger.Errors)
+
+	// Other structs are unaffected.
+	var s struct {
+		FieldA string `json:"fieldA"`
+	}
+	require.NoError(t, api.Unmarshal([]byte(`{"fieldA":"a"}`), &s))
+	assert.Equal(t, "a", s.FieldA)
+}
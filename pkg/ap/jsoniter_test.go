@@ -7,6 +7,7 @@ import (
 	"github.com/PennState/additional-properties/pkg/ap"
 	"github.com/PennState/proctor/pkg/goldenfile"
 	_ "github.com/PennState/proctor/pkg/log"
+	jsoniter "github.com/json-iterator/go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -25,6 +26,27 @@ var cases = []struct {
 	{"No additional properties", "noap.json", "noap.json", NewTestSimpleWithoutAP, NewZeroSimple},
 	{"Respects omitempty", "omitempty.json", "omitempty.json", NewTestOmitEmpty, NewZeroOmitEmpty},
 	{"Embedded struct with AP", "embedded.json", "embedded.json", NewTestOuter, NewZeroOuter},
+	{"Flattened embedded struct with AP", "flattened.json", "flattened.json", NewTestFlattened, NewZeroFlattened},
+	{"AP only - empty", "aponly_empty.json", "aponly_empty.json", NewTestAPOnlyEmpty, NewZeroAPOnly},
+	{"AP only - single entry", "aponly_single.json", "aponly_single.json", NewTestAPOnlySingle, NewZeroAPOnly},
+	{"AP only - multiple entries", "aponly_multiple.json", "aponly_multiple.json", NewTestAPOnlyMultiple, NewZeroAPOnly},
+	{"Slice of structs with AP", "slice.json", "slice.json", NewTestSimpleSlice, NewZeroSimpleSlice},
+	{"Array of structs with AP", "slice.json", "slice.json", NewTestSimpleArray, NewZeroSimpleArray},
+	{"Map of structs with AP", "map.json", "map.json", NewTestSimpleMap, NewZeroSimpleMap},
+	{"Map of pointers to structs with AP", "map.json", "map.json", NewTestSimplePtrMap, NewZeroSimplePtrMap},
+	{"RawMessage look-alike", "simple.json", "simple.json", NewTestRawTyped, NewZeroRawTyped},
+	{"Pointer field to struct with AP", "parent.json", "parent.json", NewTestParent, NewZeroParent},
+	{"Nested fields of structs with AP", "nested.json", "nested.json", NewTestContainer, NewZeroContainer},
+	{"AdditionalProperties field", "simple.json", "simple.json", NewTestDeclared, NewZeroDeclared},
+	{"Embedded AdditionalProperties", "simple.json", "simple.json", NewTestEmbedding, NewZeroEmbedding},
+	{"Embedded named map with wildcard tag", "simple.json", "simple.json", NewTestEmbeddedMap, NewZeroEmbeddedMap},
+	{"Embedded interface", "interface.json", "interface.json", NewTestWithInterface, NewZeroWithInterface},
+	{"Typed AP map with json.Marshaler values", "typed.json", "typed.json", NewTestReadings, NewZeroReadings},
+	{"Typed AP map with pointer values", "pointers.json", "pointers.json", NewTestStations, NewZeroStations},
+	{"Typed AP map with defined string keys", "typed.json", "typed.json", NewTestKeyedReadings, NewZeroKeyedReadings},
+	{"Raw AP map with defined string keys", "simple.json", "simple.json", NewTestKeyedRaw, NewZeroKeyedRaw},
+	{"String qualifier", "string.json", "string.json", NewTestCounted, NewZeroCounted},
+	{"Embedded named primitive", "primitive.json", "primitive.json", NewTestWithLabel, NewZeroWithLabel},
 }
 
 func TestMarshaling(t *testing.T) {
@@ -56,3 +78,26 @@ func TestUnmarshaling(t *testing.T) {
 		})
 	}
 }
+
+func TestRegisterTwice(t *testing.T) {
+	var first, second int
+	api := ap.RegisterAdditionalPropertiesExtension(
+		jsoniter.Config{}.Froze(),
+		ap.WithDecodeStats(func(ap.DecodeStats) { first++ }),
+	)
+	assert.Same(t, api, ap.RegisterAdditionalPropertiesExtension(
+		api,
+		ap.WithDecodeStats(func(ap.DecodeStats) { second++ }),
+	))
+
+	var s Simple
+	data := []byte(`{"fieldA":"Field A","fieldB":"Field B","fieldC":"Field C"}`)
+	require.NoError(t, api.Unmarshal(data, &s))
+	assert.Equal(t, NewTestSimple(), &s)
+	assert.Equal(t, 1, first)
+	assert.Equal(t, 0, second)
+
+	actual, err := api.Marshal(s)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(data), string(actual))
+}
@@ -0,0 +1,45 @@
+package ap_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/PennState/additional-properties/pkg/ap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Labeled has a named map field alongside its AP field.
+type Labeled struct {
+	Name   string                     `json:"name"`
+	Labels map[string]string          `json:"labels"`
+	AP     map[string]json.RawMessage `json:"*"`
+}
+
+// TestNamedMapField verifies that a named map field is decoded like any
+// other named field - "labels" is routed to it, not to the AP field.
+func TestNamedMapField(t *testing.T) {
+	api := ap.ConfigCompatibleWithStandardLibrary
+	data := []byte(`{"name":"n","labels":{"env":"prod","tier":"web"},"owner":"ops","extra":{"env":"dev"}}`)
+	var v Labeled
+	require.NoError(t, api.Unmarshal(data, &v))
+	assert.Equal(t, Labeled{
+		Name:   "n",
+		Labels: map[string]string{"env": "prod", "tier": "web"},
+		AP: map[string]json.RawMessage{
+			"owner": json.RawMessage(`"ops"`),
+			"extra": json.RawMessage(`{"env":"dev"}`),
+		},
+	}, v)
+
+	actual, err := api.Marshal(v)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(data), string(actual))
+
+	// Since it's a named field, a value of the wrong type is an error
+	// rather than being captured.
+	err = api.Unmarshal([]byte(`{"labels":{"env":1}}`), &Labeled{})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `"labels"`)
+	}
+}
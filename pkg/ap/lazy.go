@@ -0,0 +1,108 @@
+package ap
+
+import (
+	"fmt"
+	"sync"
+	"unsafe"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/modern-go/reflect2"
+)
+
+// lazyCodec stands in for the decoder or encoder of a struct type that's
+// decorated before it's been described.  jsoniter does this for
+// recursive types, whose own fields are decorated while the type's
+// descriptor is still being built, and for types it doesn't describe at
+// all (e.g. those with a codec from another extension).  It also stands
+// in for a type claimed by another goroutine (see claim), in which case
+// it has the type rather than a codec to fall back on.  The codec to use
+// is resolved on first use, by which time the type's own decoration has
+// completed, or the claim has been released.
+// maxHolders bounds the holder types await mints for a type.  Each is
+// only needed when the type was claimed as embedded in another struct,
+// so a handful suffices.
+const maxHolders = 8
+
+type lazyCodec struct {
+	Ext      *additionalPropertiesExtension
+	Name     string
+	Type     reflect2.Type
+	Encoding bool
+	Decoder  jsoniter.ValDecoder
+	Encoder  jsoniter.ValEncoder
+
+	once sync.Once
+}
+
+func (c *lazyCodec) resolve() {
+	c.once.Do(func() {
+		c.Ext.Mutex.Lock()
+		defer c.Ext.Mutex.Unlock()
+		var err error
+		if c.Type != nil {
+			err = c.await()
+		}
+		if err == nil {
+			err = c.Ext.Errors[c.Name]
+		}
+		if err != nil {
+			codec := &errorCodec{Err: err}
+			c.Decoder, c.Encoder = codec, codec
+			return
+		}
+		if d, ok := c.Ext.Decoders[c.Name]; ok && (c.Decoder != nil || c.Type != nil && !c.Encoding) {
+			c.Decoder = d
+		}
+		if enc, ok := c.Ext.Encoders[c.Name]; ok && (c.Encoder != nil || c.Type != nil && c.Encoding) {
+			c.Encoder = enc
+		}
+	})
+}
+
+// await waits for the claim on the type that c stands in for to be
+// released, describing the type afresh if that didn't decorate it (the
+// claim being for a struct embedding it), until it's decorated.  Each
+// description mints a holder type, which is never freed, so await gives
+// up after maxHolders of them.  It's called with the mutex held.
+func (c *lazyCodec) await() error {
+	for {
+		for c.Ext.Claims[c.Name] != nil {
+			c.Ext.Released.Wait()
+		}
+		if c.Ext.Errors[c.Name] != nil {
+			return nil
+		}
+		if _, ok := c.Ext.Decoders[c.Name]; ok && !c.Encoding {
+			return nil
+		}
+		if _, ok := c.Ext.Encoders[c.Name]; ok && c.Encoding {
+			return nil
+		}
+		n := c.Ext.Holders[c.Name]
+		if n == maxHolders {
+			return fmt.Errorf("ap: %s wasn't decorated after %d descriptions", c.Name, n)
+		}
+		c.Ext.Holders[c.Name] = n + 1
+		// A type found to have no AP field isn't cached when it's described
+		// unclaimed, so it's unmarked to have the description claim it.
+		c.Ext.Plain.Delete(c.Type.Type1())
+		c.Ext.Mutex.Unlock()
+		c.Ext.describe(c, n)
+		c.Ext.Mutex.Lock()
+	}
+}
+
+func (c *lazyCodec) Decode(ptr unsafe.Pointer, iter *jsoniter.Iterator) {
+	c.resolve()
+	c.Decoder.Decode(ptr, iter)
+}
+
+func (c *lazyCodec) Encode(ptr unsafe.Pointer, stream *jsoniter.Stream) {
+	c.resolve()
+	c.Encoder.Encode(ptr, stream)
+}
+
+func (c *lazyCodec) IsEmpty(ptr unsafe.Pointer) bool {
+	c.resolve()
+	return c.Encoder.IsEmpty(ptr)
+}
@@ -0,0 +1,52 @@
+package ap_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/PennState/additional-properties/pkg/ap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// KeyedAP is an AP-enabled struct that can be used (by pointer) as a
+// map key since it implements encoding.TextMarshaler.  Struct values
+// with an AP map aren't comparable, so only pointers can be keys.
+type KeyedAP struct {
+	ID string                     `json:"id"`
+	AP map[string]json.RawMessage `json:"*"`
+}
+
+func (k *KeyedAP) MarshalText() ([]byte, error) {
+	return []byte(k.ID), nil
+}
+
+// TestMapKeyUsesTextMarshaler verifies that the AP encoder is never used
+// to write a map key - keys are written by the type's MarshalText method.
+func TestMapKeyUsesTextMarshaler(t *testing.T) {
+	key := &KeyedAP{
+		ID: "key",
+		AP: map[string]json.RawMessage{
+			"fieldB": json.RawMessage([]byte("\"Field B\"")),
+		},
+	}
+	actual, err := ap.ConfigCompatibleWithStandardLibrary.Marshal(map[*KeyedAP]int{key: 1})
+	require.NoError(t, err)
+	assert.Equal(t, `{"key":1}`, string(actual))
+}
+
+// TestMapKeyWithoutTextMarshaler verifies that an AP-enabled struct
+// pointer that can't be represented as a string is rejected with an
+// error rather than being written as an (invalid) object key, whether
+// the map's key type is known or not.
+func TestMapKeyWithoutTextMarshaler(t *testing.T) {
+	json := ap.ConfigCompatibleWithStandardLibrary
+	key, ok := NewTestSimple().(*Simple)
+	require.True(t, ok)
+	_, err := json.Marshal(map[*Simple]int{key: 1})
+	assert.ErrorIs(t, err, ap.ErrAPMapKey)
+	assert.EqualError(t, err, "additional-properties struct used as a map key: *ap_test.Simple")
+
+	_, err = json.Marshal(map[interface{}]int{key: 1})
+	assert.ErrorIs(t, err, ap.ErrAPMapKey)
+}
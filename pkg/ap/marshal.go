@@ -0,0 +1,164 @@
+package ap
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync/atomic"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/modern-go/reflect2"
+)
+
+// defaultConfig holds the defaultAPI used by the package-level helpers.
+//
+//nolint:gochecknoglobals
+var defaultConfig atomic.Value
+
+// defaultAPI wraps the default jsoniter.API, since an atomic.Value must
+// always hold values of the same concrete type.
+type defaultAPI struct {
+	API jsoniter.API
+}
+
+// DefaultConfig returns the jsoniter.API used by Marshal, Unmarshal and
+// the helpers built on them, which is ConfigCompatibleWithStandardLibrary
+// unless it's been replaced using SetDefaultConfig.  It's safe for
+// concurrent use.
+func DefaultConfig() jsoniter.API {
+	if d, ok := defaultConfig.Load().(defaultAPI); ok {
+		return d.API
+	}
+	return ConfigCompatibleWithStandardLibrary
+}
+
+// SetDefaultConfig atomically replaces the jsoniter.API returned by
+// DefaultConfig, registering the AP extension with api if it isn't
+// already registered.  Passing nil restores
+// ConfigCompatibleWithStandardLibrary.  It's safe to call concurrently
+// with the package-level helpers, each of which uses either the previous
+// or the new API for the whole of a call.
+func SetDefaultConfig(api jsoniter.API) {
+	if api == nil {
+		api = ConfigCompatibleWithStandardLibrary
+	}
+	defaultConfig.Store(defaultAPI{API: RegisterAdditionalPropertiesExtension(api)})
+}
+
+// Marshal returns the JSON encoding of v, including any additional
+// properties, using DefaultConfig.
+func Marshal(v interface{}) ([]byte, error) {
+	return DefaultConfig().Marshal(v)
+}
+
+// EncodeToStream writes the JSON encoding of v, including any additional
+// properties, to stream, so AP-aware values can be composed into larger
+// streamed documents without marshaling each to its own buffer.  If the
+// API that created stream has the AP extension registered, its encoder
+// for v is used (as by stream.WriteVal), and otherwise that of
+// DefaultConfig is.  The stream isn't flushed, and its error is returned.
+func EncodeToStream(stream *jsoniter.Stream, v interface{}) error {
+	switch _, ok := registry.Load(stream.Pool()); {
+	case v == nil:
+		stream.WriteNil()
+	case ok:
+		stream.WriteVal(v)
+	default:
+		DefaultConfig().EncoderOf(reflect2.TypeOf(v)).Encode(reflect2.PtrOf(v), stream)
+	}
+	return stream.Error
+}
+
+// MarshalIndent is like Marshal but applies json.Indent to format the
+// output, mirroring json.MarshalIndent.  Each JSON element begins on a
+// new line starting with prefix followed by one or more copies of indent
+// according to the nesting.  Unlike jsoniter's IndentionStep, any prefix
+// and indent are supported, and raw values are re-indented too.
+func MarshalIndent(v interface{}, prefix, indent string) ([]byte, error) {
+	b, err := Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, b, prefix, indent); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes data into v, collecting any additional properties,
+// using DefaultConfig.
+//
+// Named fields are decoded eagerly, so a value that doesn't suit the
+// field's type fails the decode.  Additional properties held as raw JSON
+// are captured lazily instead: any syntactically valid value (whatever
+// its type) is stored without error, and is only interpreted when it's
+// accessed.  The document as a whole must still be valid JSON (and
+// jsoniter rejects numbers that overflow a float64 when they're nested
+// within a raw value), and typed AP maps decode their values eagerly,
+// like named fields.
+func Unmarshal(data []byte, v interface{}) error {
+	return DefaultConfig().Unmarshal(data, v)
+}
+
+// withoutAP is the Attachment of the iterators and streams used by
+// UnmarshalWithoutAP and MarshalWithoutAP.  jsoniter caches a single
+// codec for each type per API, so the AP codecs can only be switched off
+// for a call by checking the iterator or stream they're passed.
+type withoutAP struct{}
+
+// MarshalWithoutAP returns the JSON encoding of v using api, leaving out
+// the AP field of every struct, so its additional properties aren't
+// written (nor is the field written as a named one).  The named fields
+// are written as usual.  This avoids building a second API (with its own
+// cache of codecs) for the occasional strict call.
+func MarshalWithoutAP(api jsoniter.API, v interface{}) ([]byte, error) {
+	stream := api.BorrowStream(nil)
+	defer api.ReturnStream(stream)
+	stream.Attachment = withoutAP{}
+	stream.WriteVal(v)
+	if stream.Error != nil {
+		return nil, stream.Error
+	}
+	return append([]byte(nil), stream.Buffer()...), nil
+}
+
+// UnmarshalWithoutAP decodes data into v using api, but discards the
+// additional properties of any struct rather than collecting them, as
+// if api didn't have the AP extension registered.  The AP fields of the
+// structs decoded are left untouched.
+func UnmarshalWithoutAP(api jsoniter.API, data []byte, v interface{}) error {
+	iter := api.BorrowIterator(data)
+	defer api.ReturnIterator(iter)
+	iter.Attachment = withoutAP{}
+	iter.ReadVal(v)
+	// As with jsoniter's Unmarshal, trailing data is an error, and
+	// reaching the end of the data isn't.
+	if iter.Error == nil {
+		iter.WhatIsNext()
+		if iter.Error == nil {
+			iter.ReportError("UnmarshalWithoutAP", "there are bytes left after unmarshal")
+		}
+	}
+	if iter.Error == io.EOF {
+		return nil
+	}
+	return iter.Error
+}
+
+// Decode returns the T decoded from data using Unmarshal.  If decoding
+// fails, the zero value of T is returned rather than a partially decoded
+// value.
+func Decode[T any](data []byte) (T, error) {
+	var v T
+	if err := Unmarshal(data, &v); err != nil {
+		var zero T
+		return zero, err
+	}
+	return v, nil
+}
+
+// Encode returns the JSON encoding of v using Marshal.
+func Encode[T any](v T) ([]byte, error) {
+	return Marshal(v)
+}
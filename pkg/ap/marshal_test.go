@@ -0,0 +1,158 @@
+package ap_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/PennState/additional-properties/pkg/ap"
+	"github.com/PennState/proctor/pkg/goldenfile"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeEncode(t *testing.T) {
+	data := []byte(`{"fieldA":"Field A","fieldB":"Field B","fieldC":"Field C"}`)
+	s, err := ap.Decode[Simple](data)
+	require.NoError(t, err)
+	assert.Equal(t, NewTestSimple(), &s)
+
+	actual, err := ap.Encode(s)
+	require.NoError(t, err)
+	goldenfile.AssertJSONEq(t, goldenfile.GetDefaultFilePath("simple.json"), string(actual))
+}
+
+func TestDecodeErrorReturnsZeroValue(t *testing.T) {
+	p, err := ap.Decode[Person]([]byte(`{"name":"Pat","age":"old"}`))
+	assert.Error(t, err)
+	assert.Equal(t, Person{}, p)
+}
+
+func TestMarshalIndent(t *testing.T) {
+	v := Simple{
+		FieldA: "Field A",
+		AP: map[string]json.RawMessage{
+			"nested": json.RawMessage(`{"list":[1,2]}`),
+		},
+	}
+	actual, err := ap.MarshalIndent(v, "> ", "\t")
+	require.NoError(t, err)
+	assert.Equal(t, "{\n"+
+		"> \t\"fieldA\": \"Field A\",\n"+
+		"> \t\"nested\": {\n"+
+		"> \t\t\"list\": [\n"+
+		"> \t\t\t1,\n"+
+		"> \t\t\t2\n"+
+		"> \t\t]\n"+
+		"> \t}\n"+
+		"> }", string(actual))
+}
+
+func TestSetDefaultConfig(t *testing.T) {
+	assert.Same(t, ap.ConfigCompatibleWithStandardLibrary, ap.DefaultConfig())
+	t.Cleanup(func() { ap.SetDefaultConfig(nil) })
+
+	api := jsoniter.Config{}.Froze()
+	ap.SetDefaultConfig(api)
+	assert.Same(t, api, ap.DefaultConfig())
+
+	// The extension is registered with the new default.
+	var s Simple
+	require.NoError(t, ap.Unmarshal([]byte(`{"fieldA":"Field A","fieldB":"<b>"}`), &s))
+	assert.Equal(t, json.RawMessage(`"<b>"`), s.AP["fieldB"])
+	actual, err := ap.Marshal(s)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"fieldA":"Field A","fieldB":"<b>"}`, string(actual))
+	assert.Contains(t, string(actual), `"<b>"`)
+
+	// As do the helpers.
+	require.NoError(t, ap.SetAdditionalProperty(&s, "fieldC", "<c>"))
+	assert.Equal(t, json.RawMessage(`"<c>"`), s.AP["fieldC"])
+
+	ap.SetDefaultConfig(nil)
+	assert.Same(t, ap.ConfigCompatibleWithStandardLibrary, ap.DefaultConfig())
+	actual, err = ap.Marshal(s)
+	require.NoError(t, err)
+	assert.Contains(t, string(actual), `"\u003cb\u003e"`)
+}
+
+func TestSetDefaultConfigConcurrently(t *testing.T) {
+	t.Cleanup(func() { ap.SetDefaultConfig(nil) })
+	apis := []jsoniter.API{ap.ConfigCompatibleWithStandardLibrary, jsoniter.Config{}.Froze()}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if i%2 == 0 {
+					ap.SetDefaultConfig(apis[j%2])
+					continue
+				}
+				s, err := ap.Decode[Simple]([]byte(`{"fieldA":"Field A","fieldB":"Field B"}`))
+				assert.NoError(t, err)
+				assert.Equal(t, "Field A", s.FieldA)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestEncodeToStream(t *testing.T) {
+	s := NewTestSimple()
+	for name, api := range map[string]jsoniter.API{
+		"Registered":     ap.ConfigCompatibleWithStandardLibrary,
+		"Not registered": jsoniter.ConfigDefault,
+	} {
+		api := api
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			stream := jsoniter.NewStream(api, &buf, 16)
+			stream.WriteObjectStart()
+			stream.WriteObjectField("items")
+			stream.WriteArrayStart()
+			require.NoError(t, ap.EncodeToStream(stream, s))
+			stream.WriteMore()
+			require.NoError(t, ap.EncodeToStream(stream, nil))
+			stream.WriteArrayEnd()
+			stream.WriteObjectEnd()
+			require.NoError(t, stream.Flush())
+
+			assert.JSONEq(t, `{"items":[{"fieldA":"Field A","fieldB":"Field B","fieldC":"Field C"},null]}`, buf.String())
+		})
+	}
+
+	stream := jsoniter.NewStream(ap.ConfigCompatibleWithStandardLibrary, nil, 16)
+	assert.Error(t, ap.EncodeToStream(stream, Channels{}))
+}
+
+func TestWithoutAP(t *testing.T) {
+	api := ap.ConfigCompatibleWithStandardLibrary
+	data := []byte(`{"fieldP":"Field P","child":{"fieldA":"Field A","fieldB":"Field B"},"fieldQ":"Field Q"}`)
+
+	var p Parent
+	require.NoError(t, ap.UnmarshalWithoutAP(api, data, &p))
+	assert.Equal(t, Parent{FieldP: "Field P", Child: &Simple{FieldA: "Field A"}}, p)
+
+	// The same API still collects additional properties otherwise.
+	var s Simple
+	require.NoError(t, api.Unmarshal(data, &s))
+	assert.Len(t, s.AP, 3)
+
+	actual, err := ap.MarshalWithoutAP(api, NewTestParent())
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"fieldP":"Field P","child":{"fieldA":"Field A"}}`, string(actual))
+	actual, err = api.Marshal(NewTestParent())
+	require.NoError(t, err)
+	goldenfile.AssertJSONEq(t, goldenfile.GetDefaultFilePath("parent.json"), string(actual))
+
+	err = ap.UnmarshalWithoutAP(api, []byte(`{"fieldA":"Field A"} {}`), &s)
+	assert.Error(t, err)
+	err = ap.UnmarshalWithoutAP(api, []byte(`{"fieldA":1}`), &s)
+	assert.Error(t, err)
+	_, err = ap.MarshalWithoutAP(api, Channels{})
+	assert.ErrorIs(t, err, ap.ErrUnsupportedAPType)
+}
@@ -0,0 +1,76 @@
+package ap_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/PennState/additional-properties/pkg/ap"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// SelfCoding has an AP field, but implements json.Unmarshaler and
+// json.Marshaler, so collecting its additional properties is up to it.
+type SelfCoding struct {
+	Name string                     `json:"name"`
+	AP   map[string]json.RawMessage `json:"*"`
+}
+
+func (s *SelfCoding) UnmarshalJSON(data []byte) error {
+	s.Name = "custom"
+	return json.Unmarshal(data, &s.AP)
+}
+
+func (s SelfCoding) MarshalJSON() ([]byte, error) {
+	return []byte(`{"custom":true}`), nil
+}
+
+// PtrCoding implements json.Marshaler with a pointer receiver, which
+// (as with encoding/json) is only used for addressable values.
+type PtrCoding struct {
+	Name string                     `json:"name"`
+	AP   map[string]json.RawMessage `json:"*"`
+}
+
+func (p *PtrCoding) MarshalJSON() ([]byte, error) {
+	return []byte(`"custom"`), nil
+}
+
+type CodingHolder struct {
+	Self SelfCoding `json:"self"`
+	Ptr  PtrCoding  `json:"ptr"`
+}
+
+func TestCustomMarshalers(t *testing.T) {
+	api := ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze())
+	data := []byte(`{"name":"n","fieldB":"Field B"}`)
+
+	var s SelfCoding
+	require.NoError(t, api.Unmarshal(data, &s))
+	assert.Equal(t, SelfCoding{
+		Name: "custom",
+		AP:   map[string]json.RawMessage{"name": json.RawMessage(`"n"`), "fieldB": json.RawMessage(`"Field B"`)},
+	}, s)
+	actual, err := api.Marshal(s)
+	require.NoError(t, err)
+	assert.Equal(t, `{"custom":true}`, string(actual))
+
+	// A non-addressable value is encoded as a struct, with its additional
+	// properties.
+	actual, err = api.Marshal(PtrCoding{Name: "n", AP: map[string]json.RawMessage{"fieldB": json.RawMessage(`"Field B"`)}})
+	require.NoError(t, err)
+	assert.JSONEq(t, string(data), string(actual))
+	actual, err = api.Marshal(&PtrCoding{Name: "n"})
+	require.NoError(t, err)
+	assert.Equal(t, `"custom"`, string(actual))
+
+	// Fields use the custom methods too, even once the types have been
+	// decorated.
+	var h CodingHolder
+	require.NoError(t, api.Unmarshal([]byte(`{"self":{"a":1},"ptr":{"name":"n","a":1}}`), &h))
+	assert.Equal(t, "custom", h.Self.Name)
+	actual, err = api.Marshal(h)
+	require.NoError(t, err)
+	assert.Equal(t, `{"self":{"custom":true},"ptr":"custom"}`, string(actual))
+}
@@ -0,0 +1,120 @@
+package ap
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"unsafe"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/modern-go/reflect2"
+)
+
+// ErrMultipleWildcards is returned when marshaling or unmarshaling a
+// struct that has more than one AP field.
+var ErrMultipleWildcards = errors.New("multiple additional-properties fields")
+
+// ErrUnsupportedAPType is returned when marshaling or unmarshaling a
+// struct whose AP map holds values that can't be represented in JSON.
+var ErrUnsupportedAPType = errors.New("unsupported additional-properties type")
+
+// ErrUnexportedWildcard is returned when marshaling or unmarshaling a
+// struct whose AP field is unexported.  jsoniter ignores unexported
+// fields, so its additional properties would otherwise be dropped.
+var ErrUnexportedWildcard = errors.New("unexported additional-properties field")
+
+// ErrInvalidValueField is returned when marshaling or unmarshaling a
+// struct whose field set by WithInvalidValueField doesn't exist or isn't
+// a map[string]json.RawMessage.
+var ErrInvalidValueField = errors.New("invalid-values field")
+
+// ErrWildcardNotMap is reported by Validate for a struct whose wildcard
+// field isn't a map with string keys.  Such fields are otherwise ignored
+// (with a warning), and marshaled as named fields.
+var ErrWildcardNotMap = errors.New("additional-properties field isn't a map with string keys")
+
+// ErrAPMapKey is returned when marshaling a map whose keys are structs
+// with an AP field (or pointers to them) which don't implement
+// encoding.TextMarshaler.  Keys are written as strings, which the object
+// holding the additional properties can't be.
+var ErrAPMapKey = errors.New("additional-properties struct used as a map key")
+
+// CreateMapKeyEncoder rejects map keys of struct types which may have an
+// AP field, unless they implement encoding.TextMarshaler, naming the
+// type, so that the AP encoder is never asked to write a key.
+func (e *additionalPropertiesExtension) CreateMapKeyEncoder(typ reflect2.Type) jsoniter.ValEncoder {
+	if typ.Implements(textMarshalerType) {
+		return nil
+	}
+	elem := typ
+	for elem.Kind() == reflect.Ptr {
+		elem = elem.(reflect2.PtrType).Elem()
+	}
+	if elem.Kind() != reflect.Struct || len(e.claimedTypes(elem.Type1(), map[reflect.Type]bool{})) == 0 {
+		return nil
+	}
+	return &errorCodec{Err: fmt.Errorf("%w: %s", ErrAPMapKey, typ)}
+}
+
+// isUnexportedWildcard determines whether binding is an unexported field
+// tagged as the AP field.  jsoniter describes such fields without any
+// names, so they're identified by their tag instead.
+func isUnexportedWildcard(binding *jsoniter.Binding, marker string) bool {
+	if binding.Field.Anonymous() || binding.Field.PkgPath() == "" {
+		return false
+	}
+	if strings.HasPrefix(marker, ",") {
+		return isWildcard(binding, marker)
+	}
+	name, _ := jsonTag(binding.Field)
+	return name == marker
+}
+
+// isSupported determines whether values of typ can be represented in
+// JSON, including the elements of the pointers, slices, arrays and maps
+// it's made up of, so map[string][]chan int isn't.
+func isSupported(typ reflect2.Type) bool {
+	return supported(typ.Type1(), map[reflect.Type]bool{})
+}
+
+// supported implements isSupported, with seen holding the types already
+// being checked, since a type like type T []T contains itself.
+func supported(typ reflect.Type, seen map[reflect.Type]bool) bool {
+	if seen[typ] {
+		return true
+	}
+	seen[typ] = true
+	switch typ.Kind() {
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer, reflect.Complex64, reflect.Complex128:
+		return false
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return supported(typ.Elem(), seen)
+	default:
+		return true
+	}
+}
+
+// errorCodec replaces the decoder and encoder of a misconfigured struct
+// so that the first marshal or unmarshal of the type fails with Err.
+type errorCodec struct {
+	Err error
+}
+
+func (c *errorCodec) Decode(ptr unsafe.Pointer, iter *jsoniter.Iterator) {
+	iter.Skip()
+	if iter.Error == nil {
+		iter.Error = c.Err
+	}
+}
+
+func (c *errorCodec) IsEmpty(ptr unsafe.Pointer) bool {
+	return false
+}
+
+func (c *errorCodec) Encode(ptr unsafe.Pointer, stream *jsoniter.Stream) {
+	stream.WriteNil()
+	if stream.Error == nil {
+		stream.Error = c.Err
+	}
+}
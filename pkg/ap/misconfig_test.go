@@ -0,0 +1,154 @@
+package ap_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/PennState/additional-properties/pkg/ap"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TwoWildcards declares two AP fields.
+type TwoWildcards struct {
+	FieldA string                     `json:"fieldA"`
+	AP     map[string]json.RawMessage `json:"*"`
+	ap.AdditionalProperties
+}
+
+// OtherInner is another struct with an AP field.
+type OtherInner struct {
+	FieldD string `json:"fieldD"`
+	ap.AdditionalProperties
+}
+
+// TwoEmbedded embeds two structs that each have an AP field.
+type TwoEmbedded struct {
+	Inner
+	OtherInner
+}
+
+// Shadowing declares an AP field that shadows that of its embedded
+// struct.
+type Shadowing struct {
+	Inner
+	AP map[string]json.RawMessage `json:"*"`
+}
+
+func TestMultipleWildcards(t *testing.T) {
+	json := ap.ConfigCompatibleWithStandardLibrary
+	data := []byte(`{"fieldA":"Field A","fieldB":"Field B"}`)
+	tests := []struct {
+		Name string
+		Zero func() interface{}
+	}{
+		{"Declared", func() interface{} { return &TwoWildcards{} }},
+		{"Embedded", func() interface{} { return &TwoEmbedded{} }},
+	}
+	for idx := range tests {
+		test := tests[idx]
+		t.Run(test.Name, func(t *testing.T) {
+			err := json.Unmarshal(data, test.Zero())
+			assert.ErrorIs(t, err, ap.ErrMultipleWildcards)
+
+			_, err = json.Marshal(test.Zero())
+			assert.ErrorIs(t, err, ap.ErrMultipleWildcards)
+		})
+	}
+}
+
+func TestShadowedWildcard(t *testing.T) {
+	var s Shadowing
+	err := ap.Unmarshal([]byte(`{"fieldA":"Field A","fieldB":"Field B"}`), &s)
+	assert.NoError(t, err)
+	assert.Equal(t, "Field A", s.FieldA)
+	assert.Nil(t, s.Inner.AP)
+	assert.Equal(t, map[string]json.RawMessage{"fieldB": []byte(`"Field B"`)}, s.AP)
+}
+
+// Channels has an AP map whose values can't be represented in JSON.
+type Channels struct {
+	FieldA string              `json:"fieldA"`
+	AP     map[string]chan int `json:"*"`
+}
+
+func TestUnsupportedAPType(t *testing.T) {
+	json := ap.ConfigCompatibleWithStandardLibrary
+
+	// Fails even when there aren't any additional properties
+	err := json.Unmarshal([]byte(`{"fieldA":"Field A"}`), &Channels{})
+	assert.ErrorIs(t, err, ap.ErrUnsupportedAPType)
+	assert.Contains(t, err.Error(), "chan int of field AP of ap_test.Channels")
+
+	_, err = json.Marshal(Channels{FieldA: "Field A"})
+	assert.ErrorIs(t, err, ap.ErrUnsupportedAPType)
+
+	// Element types are checked too.
+	err = json.Unmarshal([]byte(`{"fieldA":"Field A"}`), &NestedChannels{})
+	assert.ErrorIs(t, err, ap.ErrUnsupportedAPType)
+	assert.Contains(t, err.Error(), "[]chan int of field AP of ap_test.NestedChannels")
+}
+
+// NestedChannels has an AP map whose values hold channels.
+type NestedChannels struct {
+	FieldA string                `json:"fieldA"`
+	AP     map[string][]chan int `json:"*"`
+}
+
+// Unexported has an AP field that jsoniter can't access.  It's untagged
+// (go vet rejects JSON tags on unexported fields), so it's identified by
+// name using WithTypeMarker.
+type Unexported struct {
+	FieldA string `json:"fieldA"`
+	extra  map[string]json.RawMessage
+}
+
+func TestUnexportedWildcard(t *testing.T) {
+	json := ap.RegisterAdditionalPropertiesExtension(
+		jsoniter.Config{}.Froze(),
+		ap.WithTypeMarker(reflect.TypeOf(Unexported{}), "extra"),
+	)
+
+	err := json.Unmarshal([]byte(`{"fieldA":"Field A","fieldB":"Field B"}`), &Unexported{})
+	assert.ErrorIs(t, err, ap.ErrUnexportedWildcard)
+	assert.Contains(t, err.Error(), "extra of ap_test.Unexported")
+
+	_, err = json.Marshal(Unexported{FieldA: "Field A"})
+	assert.ErrorIs(t, err, ap.ErrUnexportedWildcard)
+}
+
+// Misconfigured reaches a struct with each kind of unsupported AP
+// declaration through its fields.
+type Misconfigured struct {
+	Multiple   *TwoWildcards         `json:"multiple"`
+	Channels   []Channels            `json:"channels"`
+	Unexported map[string]Unexported `json:"unexported"`
+	NotAMap    NotAMap               `json:"notAMap"`
+	Simple     Simple                `json:"simple"`
+}
+
+func TestValidate(t *testing.T) {
+	t.Cleanup(func() { ap.SetDefaultConfig(nil) })
+	ap.SetDefaultConfig(ap.RegisterAdditionalPropertiesExtension(
+		jsoniter.Config{}.Froze(),
+		ap.WithTypeMarker(reflect.TypeOf(Unexported{}), "extra"),
+	))
+
+	assert.NoError(t, ap.Validate(Simple{}, &Outer{}, []Node{}))
+
+	err := ap.Validate(Misconfigured{}, nil)
+	var verr *ap.ValidationError
+	require.ErrorAs(t, err, &verr)
+	require.Len(t, verr.Errors, 4)
+	assert.ErrorIs(t, verr.Errors[0], ap.ErrMultipleWildcards)
+	assert.Contains(t, verr.Errors[0].Error(), "ap_test.TwoWildcards")
+	assert.ErrorIs(t, verr.Errors[1], ap.ErrUnsupportedAPType)
+	assert.Contains(t, verr.Errors[1].Error(), "ap_test.Channels")
+	assert.ErrorIs(t, verr.Errors[2], ap.ErrUnexportedWildcard)
+	assert.Contains(t, verr.Errors[2].Error(), "ap_test.Unexported")
+	assert.ErrorIs(t, verr.Errors[3], ap.ErrWildcardNotMap)
+	assert.Contains(t, verr.Errors[3].Error(), "AP of ap_test.NotAMap")
+	assert.ErrorIs(t, err, ap.ErrUnsupportedAPType)
+}
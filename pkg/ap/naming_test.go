@@ -0,0 +1,106 @@
+package ap_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"unicode"
+
+	"github.com/PennState/additional-properties/pkg/ap"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// snakeCaseExtension names the fields without a JSON name in snake_case,
+// like a typical naming-strategy extension.
+type snakeCaseExtension struct {
+	jsoniter.DummyExtension
+}
+
+func (e *snakeCaseExtension) UpdateStructDescriptor(desc *jsoniter.StructDescriptor) {
+	for _, binding := range desc.Fields {
+		if strings.Split(binding.Field.Tag().Get("json"), ",")[0] != "" {
+			continue
+		}
+		name := snakeCase(binding.Field.Name())
+		binding.FromNames = []string{name}
+		binding.ToNames = []string{name}
+	}
+}
+
+func snakeCase(name string) string {
+	var sb strings.Builder
+	for i, r := range name {
+		if i > 0 && unicode.IsUpper(r) && unicode.IsLower(rune(name[i-1])) {
+			sb.WriteByte('_')
+		}
+		sb.WriteRune(unicode.ToLower(r))
+	}
+	return sb.String()
+}
+
+// SnakeCase has fields named by snakeCaseExtension, including one whose
+// name differs only in case from that of a tagged field.
+type SnakeCase struct {
+	NAME        string                     // name
+	Name        string                     `json:"Name"`
+	UserID      string                     // user_id
+	DisplayName string                     `json:",omitempty"` // display_name
+	AP          map[string]json.RawMessage `json:"*"`
+}
+
+func TestNamingStrategy(t *testing.T) {
+	api := ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze(), ap.WithDeterministicOutput())
+	api.RegisterExtension(&snakeCaseExtension{})
+
+	data := []byte(`{"Name":"tagged","name":"renamed","user_id":"u","UserID":"original","displayName":"d"}`)
+	var v SnakeCase
+	require.NoError(t, api.Unmarshal(data, &v))
+	assert.Equal(t, SnakeCase{
+		NAME:   "renamed",
+		Name:   "tagged",
+		UserID: "u",
+		AP: map[string]json.RawMessage{
+			"UserID":      json.RawMessage(`"original"`),
+			"displayName": json.RawMessage(`"d"`),
+		},
+	}, v)
+
+	actual, err := api.Marshal(v)
+	require.NoError(t, err)
+	assert.Equal(t, `{"name":"renamed","Name":"tagged","user_id":"u","UserID":"original","displayName":"d"}`, string(actual))
+}
+
+// Identifiers has fields whose names differ only in case.
+type Identifiers struct {
+	Upper string                     `json:"ID"`
+	Lower string                     `json:"id"`
+	Mixed string                     `json:"Key"`
+	Other string                     `json:"KEY"`
+	AP    map[string]json.RawMessage `json:"*"`
+}
+
+func TestFieldNamesDifferingInCase(t *testing.T) {
+	api := ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze(), ap.WithDeterministicOutput())
+
+	// Exact matches take precedence, whatever the order of the keys.
+	for _, data := range []string{
+		`{"ID":"upper","id":"lower","Key":"mixed","KEY":"other"}`,
+		`{"KEY":"other","Key":"mixed","id":"lower","ID":"upper"}`,
+	} {
+		var v Identifiers
+		require.NoError(t, api.Unmarshal([]byte(data), &v))
+		assert.Equal(t, Identifiers{Upper: "upper", Lower: "lower", Mixed: "mixed", Other: "other", AP: map[string]json.RawMessage{}}, v)
+	}
+
+	// Otherwise, the lowercase alias belongs to the field with that exact
+	// name, or to the first field.
+	var v Identifiers
+	require.NoError(t, api.Unmarshal([]byte(`{"Id":"lower","key":"mixed"}`), &v))
+	assert.Equal(t, Identifiers{Lower: "lower", Mixed: "mixed", AP: map[string]json.RawMessage{}}, v)
+
+	actual, err := api.Marshal(Identifiers{Upper: "upper", Lower: "lower", Mixed: "mixed", Other: "other"})
+	require.NoError(t, err)
+	assert.Equal(t, `{"ID":"upper","id":"lower","Key":"mixed","KEY":"other"}`, string(actual))
+}
@@ -1,5 +1,14 @@
 package ap_test
 
+import (
+	"testing"
+
+	"github.com/PennState/additional-properties/pkg/ap"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
 type NoAP struct {
 	FieldA string `json:"fieldA"`
 }
@@ -13,3 +22,18 @@ func NewTestNoAP() interface{} {
 		FieldA: "Field A",
 	}
 }
+
+func TestNoAPAllocations(t *testing.T) {
+	data := []byte(`{"fieldA":"Field A","fieldB":"Field B","fieldC":"Field C"}`)
+	allocs := func(api jsoniter.API) float64 {
+		var v NoAP
+		require.NoError(t, api.Unmarshal(data, &v))
+		return testing.AllocsPerRun(100, func() {
+			var v NoAP
+			_ = api.Unmarshal(data, &v)
+		})
+	}
+
+	// Structs without an AP field are decoded by jsoniter's own decoder.
+	assert.Equal(t, allocs(jsoniter.ConfigCompatibleWithStandardLibrary), allocs(ap.ConfigCompatibleWithStandardLibrary))
+}
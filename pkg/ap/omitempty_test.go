@@ -1,8 +1,18 @@
 package ap_test
 
 import (
+	"database/sql"
 	"encoding/json"
+	"fmt"
+	"testing"
 	"time"
+	"unsafe"
+
+	"github.com/PennState/additional-properties/pkg/ap"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/modern-go/reflect2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // OmitEmpty is the most basic struct to which we can add additional-
@@ -42,3 +52,226 @@ func NewTestOmitEmpty() interface{} {
 		},
 	}
 }
+
+// OmitEmptyKinds holds one omitempty field of each kind whose emptiness
+// is decided by length or nil-ness rather than by a zero value.
+type OmitEmptyKinds struct {
+	Map        map[string]string          `json:"map,omitempty"`
+	Slice      []string                   `json:"slice,omitempty"`
+	Array      [0]string                  `json:"array,omitempty"`
+	Pointer    *string                    `json:"pointer,omitempty"`
+	PtrToMap   *map[string]string         `json:"ptrToMap,omitempty"`
+	PtrToSlice *[]string                  `json:"ptrToSlice,omitempty"`
+	PtrToPtr   **string                   `json:"ptrToPtr,omitempty"`
+	Interface  interface{}                `json:"interface,omitempty"`
+	AP         map[string]json.RawMessage `json:"*"`
+}
+
+func TestOmitEmptyMatchesEncodingJSON(t *testing.T) {
+	empty := ""
+	emptyPtr := &empty
+	var nilPtr *string
+	tests := []struct {
+		Name  string
+		Input OmitEmptyKinds
+	}{
+		{"All nil", OmitEmptyKinds{}},
+		{"Empty map", OmitEmptyKinds{Map: map[string]string{}}},
+		{"Populated map", OmitEmptyKinds{Map: map[string]string{"a": "b"}}},
+		{"Empty slice", OmitEmptyKinds{Slice: []string{}}},
+		{"Populated slice", OmitEmptyKinds{Slice: []string{"a"}}},
+		{"Pointer to empty string", OmitEmptyKinds{Pointer: &empty}},
+		{"Pointer to nil map", OmitEmptyKinds{PtrToMap: new(map[string]string)}},
+		{"Pointer to empty map", OmitEmptyKinds{PtrToMap: &map[string]string{}}},
+		{"Pointer to nil slice", OmitEmptyKinds{PtrToSlice: new([]string)}},
+		{"Pointer to empty slice", OmitEmptyKinds{PtrToSlice: &[]string{}}},
+		{"Pointer to nil pointer", OmitEmptyKinds{PtrToPtr: &nilPtr}},
+		{"Pointer to pointer", OmitEmptyKinds{PtrToPtr: &emptyPtr}},
+		{"Interface holding nil pointer", OmitEmptyKinds{Interface: nilPtr}},
+		{"Interface holding empty slice", OmitEmptyKinds{Interface: []string{}}},
+	}
+	for idx := range tests {
+		test := tests[idx]
+		t.Run(test.Name, func(t *testing.T) {
+			// encoding/json writes the wildcard field as "*", so it is
+			// removed before comparing.
+			var expected map[string]interface{}
+			std, err := json.Marshal(test.Input)
+			require.NoError(t, err)
+			require.NoError(t, json.Unmarshal(std, &expected))
+			delete(expected, "*")
+			std, err = json.Marshal(expected)
+			require.NoError(t, err)
+
+			actual, err := ap.Marshal(test.Input)
+			require.NoError(t, err)
+			assert.JSONEq(t, string(std), string(actual))
+		})
+	}
+}
+
+// OmitEmptyAP has an AP field tagged omitempty.
+type OmitEmptyAP struct {
+	Field string                     `json:"field"`
+	Other string                     `json:"other,omitempty"`
+	AP    map[string]json.RawMessage `json:"*,omitempty"`
+}
+
+func TestOmitEmptyAPField(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Input    OmitEmptyAP
+		Expected string
+	}{
+		{"Nil AP map", OmitEmptyAP{Field: "x"}, `{"field":"x"}`},
+		{"Empty AP map", OmitEmptyAP{Field: "x", AP: map[string]json.RawMessage{}}, `{"field":"x"}`},
+		{"Trailing field", OmitEmptyAP{Field: "x", Other: "y"}, `{"field":"x","other":"y"}`},
+		{"Populated AP map", OmitEmptyAP{Field: "x", AP: map[string]json.RawMessage{"a": json.RawMessage("1")}}, `{"field":"x","a":1}`},
+	}
+	t.Run("Default", func(t *testing.T) {
+		// Named fields are written in any order, so only artifacts that
+		// make the output invalid (or change its content) are detected.
+		for _, test := range tests {
+			actual, err := ap.Marshal(test.Input)
+			require.NoError(t, err, test.Name)
+			assert.JSONEq(t, test.Expected, string(actual), test.Name)
+		}
+	})
+	t.Run("Deterministic", func(t *testing.T) {
+		api := ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze(), ap.WithDeterministicOutput())
+		for _, test := range tests {
+			actual, err := api.Marshal(test.Input)
+			require.NoError(t, err, test.Name)
+			assert.Equal(t, test.Expected, string(actual), test.Name)
+		}
+	})
+
+	// Decoding an object without additional properties
+	var v OmitEmptyAP
+	require.NoError(t, ap.Unmarshal([]byte(`{"field":"x"}`), &v))
+	actual, err := ap.Marshal(v)
+	require.NoError(t, err)
+	assert.Equal(t, `{"field":"x"}`, string(actual))
+}
+
+// Sparse only has omitempty named fields, so either they or its
+// additional properties (or both, or neither) can be written.
+type Sparse struct {
+	A  string                     `json:"a,omitempty"`
+	B  string                     `json:"b,omitempty"`
+	AP map[string]json.RawMessage `json:"*"`
+}
+
+// TestCommaPlacement covers the combinations of named fields and
+// additional properties being written, since only values after the
+// first are preceded by a comma.
+func TestCommaPlacement(t *testing.T) {
+	entries := map[string]json.RawMessage{"x": json.RawMessage("1"), "y": json.RawMessage("2")}
+	tests := []struct {
+		Name     string
+		Input    Sparse
+		Expected string
+	}{
+		{"Fields and AP", Sparse{A: "a", B: "b", AP: entries}, `{"a":"a","b":"b","x":1,"y":2}`},
+		{"Fields only", Sparse{A: "a", B: "b"}, `{"a":"a","b":"b"}`},
+		{"AP only", Sparse{AP: entries}, `{"x":1,"y":2}`},
+		{"Neither", Sparse{}, `{}`},
+		{"Last field and AP", Sparse{B: "b", AP: entries}, `{"b":"b","x":1,"y":2}`},
+	}
+	t.Run("Default", func(t *testing.T) {
+		for _, test := range tests {
+			actual, err := ap.Marshal(test.Input)
+			require.NoError(t, err, test.Name)
+			assert.True(t, json.Valid(actual), test.Name)
+			assert.JSONEq(t, test.Expected, string(actual), test.Name)
+		}
+	})
+	t.Run("Deterministic", func(t *testing.T) {
+		api := ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze(), ap.WithDeterministicOutput())
+		for _, test := range tests {
+			actual, err := api.Marshal(test.Input)
+			require.NoError(t, err, test.Name)
+			assert.Equal(t, test.Expected, string(actual), test.Name)
+		}
+	})
+}
+
+// Reading has an IsEmpty that's decided by its jsoniter encoder, which
+// treats readings without a unit as empty.
+type Reading struct {
+	Value float64
+	Unit  string
+}
+
+// readingExtension encodes Readings as strings.
+type readingExtension struct {
+	jsoniter.DummyExtension
+}
+
+func (e *readingExtension) CreateEncoder(typ reflect2.Type) jsoniter.ValEncoder {
+	if typ != reflect2.TypeOf(Reading{}) {
+		return nil
+	}
+	return readingEncoder{}
+}
+
+type readingEncoder struct{}
+
+func (readingEncoder) Encode(ptr unsafe.Pointer, stream *jsoniter.Stream) {
+	r := (*Reading)(ptr)
+	stream.WriteString(fmt.Sprint(r.Value, r.Unit))
+}
+
+func (readingEncoder) IsEmpty(ptr unsafe.Pointer) bool {
+	return (*Reading)(ptr).Unit == ""
+}
+
+// OmitEmptyCustom holds omitempty fields whose emptiness is decided by
+// their (struct kind or custom) encoders.
+type OmitEmptyCustom struct {
+	Time    time.Time                  `json:"time,omitempty"`
+	Reading Reading                    `json:"reading,omitempty"`
+	String  sql.NullString             `json:"string,omitempty"`
+	Int     sql.NullInt64              `json:"int,omitempty"`
+	AP      map[string]json.RawMessage `json:"*"`
+}
+
+func TestOmitEmptyMatchesJsoniter(t *testing.T) {
+	plain := jsoniter.Config{}.Froze()
+	plain.RegisterExtension(&readingExtension{})
+	api := jsoniter.Config{}.Froze()
+	api.RegisterExtension(&readingExtension{})
+	api = ap.RegisterAdditionalPropertiesExtension(api)
+
+	tests := []struct {
+		Name  string
+		Input OmitEmptyCustom
+	}{
+		{"Zero values", OmitEmptyCustom{}},
+		{"Time", OmitEmptyCustom{Time: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}},
+		{"Empty reading", OmitEmptyCustom{Reading: Reading{Value: 1}}},
+		{"Reading", OmitEmptyCustom{Reading: Reading{Value: 1, Unit: "C"}}},
+		{"Valid null types", OmitEmptyCustom{
+			String: sql.NullString{String: "s", Valid: true},
+			Int:    sql.NullInt64{Int64: 1, Valid: true},
+		}},
+	}
+	for idx := range tests {
+		test := tests[idx]
+		t.Run(test.Name, func(t *testing.T) {
+			// Without the extension, the wildcard field is written as "*",
+			// so it is removed before comparing.
+			var expected map[string]interface{}
+			out, err := plain.Marshal(test.Input)
+			require.NoError(t, err)
+			require.NoError(t, json.Unmarshal(out, &expected))
+			delete(expected, "*")
+			out, err = json.Marshal(expected)
+			require.NoError(t, err)
+
+			actual, err := api.Marshal(test.Input)
+			require.NoError(t, err)
+			assert.JSONEq(t, string(out), string(actual))
+		})
+	}
+}
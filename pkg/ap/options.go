@@ -0,0 +1,487 @@
+package ap
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/modern-go/reflect2"
+)
+
+// defaultWildcard is the JSON tag name which identifies the AP field.
+const defaultWildcard = "*"
+
+// Option configures the additional-properties extension when it's
+// registered with a jsoniter.API.
+type Option func(*options)
+
+type options struct {
+	OnDecode func(DecodeStats)
+	Keys     bool
+	Prefixes []string
+	Values   *interner
+	Stats    *stats
+	Log      Logger
+	PreKey   func(string) string
+	APKey    func(string) string
+	Absent   map[string]bool
+	Wildcard string
+	Markers  map[string]string
+	Ignored  map[string]map[string]bool
+	Stream   func(string, *jsoniter.Iterator)
+	Maps     map[string]func() interface{}
+
+	Limit         int
+	LimitBehavior LimitBehavior
+	Threshold     int
+	Invalid       InvalidValueBehavior
+	InvalidFields map[string]string
+
+	FieldOrder bool
+	SortAP     bool
+	Canonical  bool
+	StdFloats  bool
+	OmitNull   bool
+	Omit       func(string, json.RawMessage) bool
+	OmitKeys   []func(string) bool
+
+	// EscapeHTML, ValidateRaw and FoldCase mirror the settings of the API
+	// the extension is registered with, the last being overridden per
+	// type by CaseInsensitive.
+	EscapeHTML      bool
+	ValidateRaw     bool
+	FoldCase        bool
+	CaseSensitive   *bool
+	CaseInsensitive map[string]bool
+}
+
+// Logger is the subset of logrus.FieldLogger (plus Trace) that the
+// extension logs to, which is satisfied by *logrus.Logger and
+// *logrus.Entry.
+type Logger interface {
+	Trace(args ...interface{})
+	Debug(args ...interface{})
+	Warn(args ...interface{})
+	Error(args ...interface{})
+}
+
+// DecodeStats describes the additional properties collected while
+// decoding a single JSON object.
+type DecodeStats struct {
+	// Type is the name of the struct type that was decoded.
+	Type string
+	// AdditionalProperties is the number of keys captured in the AP map.
+	AdditionalProperties int
+	// PrefixCounts holds the number of captured keys starting with each
+	// prefix configured using WithPrefixCounts.
+	PrefixCounts map[string]int
+	// Keys holds the captured keys, in the order they were encountered,
+	// when WithCapturedKeys is set.
+	Keys []string
+}
+
+// WithDecodeStats registers a callback that's invoked with the
+// DecodeStats for each object decoded into a struct with an AP field.
+func WithDecodeStats(fn func(DecodeStats)) Option {
+	return func(o *options) {
+		o.OnDecode = fn
+	}
+}
+
+// WithCapturedKeys reports the keys captured as additional properties
+// via the WithDecodeStats callback, so unexpected keys can be logged
+// while the data is still preserved.  Each decode reports a new slice,
+// so no state is shared between (possibly concurrent) decodes, though
+// the callback itself must be safe for concurrent use.
+func WithCapturedKeys() Option {
+	return func(o *options) {
+		o.Keys = true
+	}
+}
+
+// WithPrefixCounts enables counting the captured additional properties
+// by key prefix.  Each key is counted against the first matching prefix
+// and the counts are reported via the WithDecodeStats callback.
+func WithPrefixCounts(prefixes ...string) Option {
+	return func(o *options) {
+		o.Prefixes = append(o.Prefixes, prefixes...)
+	}
+}
+
+// WithValueInterning enables sharing the backing arrays of identical
+// additional property values, which reduces memory use when decoding
+// many objects with repeated values (e.g. enum-like strings).  Interned
+// values are shared across decoded structs and must not be modified.
+// The interner is never pruned and stops adding values once it holds
+// 4096 of them or 1MiB in total, after which new values are copied as
+// usual, so it's best suited to low-cardinality values.  Only AP maps
+// holding raw JSON values are interned.
+func WithValueInterning() Option {
+	return func(o *options) {
+		o.Values = newInterner()
+	}
+}
+
+// WithKeyPreprocessor registers a function that's applied to every key
+// of a decoded object before it's matched against the struct's fields,
+// so the transformed key is used both for matching and, when no field
+// matches, as the additional property's name.
+func WithKeyPreprocessor(fn func(key string) string) Option {
+	return func(o *options) {
+		o.PreKey = fn
+	}
+}
+
+// WithKeyNormalizer registers a function that's applied to the names of
+// additional properties before they're stored in the AP map (e.g.
+// strings.ToLower for predictable lookups).  Unlike WithKeyPreprocessor,
+// it doesn't affect matching against the struct's fields.  By default,
+// additional properties keep the key exactly as it appears in the JSON.
+func WithKeyNormalizer(fn func(key string) string) Option {
+	return func(o *options) {
+		o.APKey = fn
+	}
+}
+
+// WithAbsentMarkers maps additional properties whose value is one of the
+// passed strings (e.g. "undefined") to a nil json.RawMessage, the same
+// value that an explicit JSON null decodes to.  Typed AP maps hold the
+// zero value of their element type instead, such as nil for
+// map[string]interface{}.  This accommodates lenient producers that
+// emit marker strings for absent values.
+func WithAbsentMarkers(markers ...string) Option {
+	return func(o *options) {
+		if o.Absent == nil {
+			o.Absent = map[string]bool{}
+		}
+		for _, m := range markers {
+			quoted, _ := json.Marshal(m)
+			o.Absent[string(quoted)] = true
+		}
+	}
+}
+
+// WithDeterministicOutput makes encoding reproducible byte-for-byte:
+// named fields are written in declaration order, followed by additional
+// properties in lexicographic key order, regardless of the API's
+// SortMapKeys setting.  It combines WithFieldOrder and
+// WithSortedAdditionalProperties.  This is the recommended setting for
+// snapshot (golden file) tests.
+func WithDeterministicOutput() Option {
+	return func(o *options) {
+		o.FieldOrder = true
+		o.SortAP = true
+	}
+}
+
+// WithFieldOrder makes encoding write named fields in declaration order,
+// rather than in jsoniter's (map) order.
+func WithFieldOrder() Option {
+	return func(o *options) {
+		o.FieldOrder = true
+	}
+}
+
+// WithSortedAdditionalProperties makes encoding write additional
+// properties in lexicographic key order, regardless of the API's
+// SortMapKeys setting.
+func WithSortedAdditionalProperties() Option {
+	return func(o *options) {
+		o.SortAP = true
+	}
+}
+
+// WithCanonicalValues makes encoding re-encode each additional property
+// held as raw JSON through the API, rather than writing it verbatim.  The
+// value is decoded into a generic value (numbers being kept as written,
+// as json.Number), so with the API's SortMapKeys setting (as in
+// ConfigCompatibleWithStandardLibrary) the keys of nested objects are
+// sorted, and whitespace and string escaping follow the API's settings.
+// This favors consistent output over speed; invalid values are written as
+// they would be without it.  Typed AP maps are unaffected.
+func WithCanonicalValues() Option {
+	return func(o *options) {
+		o.Canonical = true
+	}
+}
+
+// WithStandardFloats makes the API encode float32 and float64 values as
+// encoding/json does, trimming the exponent so that 1e-7 is written as
+// 1e-7 rather than 1e-07.  It replaces jsoniter's float encoders for
+// every value the API encodes, not only for types with an AP field.
+// ConfigCompatibleWithStandardLibrary is registered with it.
+func WithStandardFloats() Option {
+	return func(o *options) {
+		o.StdFloats = true
+	}
+}
+
+// WithCaseSensitivity sets whether keys are matched to fields with
+// regard to case for every type, overriding the API's CaseSensitive
+// setting.  WithCaseInsensitivity still takes precedence for the types
+// it's passed.
+func WithCaseSensitivity(sensitive bool) Option {
+	return func(o *options) {
+		o.CaseSensitive = &sensitive
+	}
+}
+
+// WithLogger sets the Logger the extension logs to, which is logrus's
+// standard logger by default.
+func WithLogger(logger Logger) Option {
+	return func(o *options) {
+		o.Log = logger
+	}
+}
+
+// WithWildcardTag sets the JSON tag name (e.g. "-extra") which identifies
+// the AP field of every struct type, overriding the default "*".
+// WithTypeMarker still takes precedence for the types it's passed, and
+// an embedded AdditionalProperties is always the AP field.
+func WithWildcardTag(tag string) Option {
+	return func(o *options) {
+		o.Wildcard = tag
+	}
+}
+
+// WithoutNulls omits additional properties whose raw value is the JSON
+// literal null (or nil) when encoding, for APIs that treat an explicit
+// null as absent.  Only AP maps holding raw JSON values are affected.  By
+// default, null values are written.
+func WithoutNulls() Option {
+	return func(o *options) {
+		o.OmitNull = true
+	}
+}
+
+// WithOmitPredicate omits, when encoding, the additional properties for
+// which fn returns true, e.g. those whose raw value is an empty string,
+// object or array.  It's applied after WithoutNulls, and only AP maps
+// holding raw JSON values are affected.  By default, every additional
+// property is written.
+func WithOmitPredicate(fn func(key string, raw json.RawMessage) bool) Option {
+	return func(o *options) {
+		o.Omit = fn
+	}
+}
+
+// WithOmittedKeys omits, when encoding, the additional properties whose
+// keys fn returns true for, e.g. those with an "_internal_" prefix that
+// mustn't leave the service.  Unlike WithOmitPredicate, AP maps of every
+// type are affected.  It can be passed several times (along with
+// WithOmittedKeyPattern), in which case a key matching any of them is
+// omitted.  Decoding is unaffected.
+func WithOmittedKeys(fn func(key string) bool) Option {
+	return func(o *options) {
+		o.OmitKeys = append(o.OmitKeys, fn)
+	}
+}
+
+// WithOmittedKeyPattern omits, when encoding, the additional properties
+// whose keys match re.  See WithOmittedKeys.
+func WithOmittedKeyPattern(re *regexp.Regexp) Option {
+	return WithOmittedKeys(re.MatchString)
+}
+
+// WithTypeMarker sets the marker which identifies the AP field of the
+// passed struct type, overriding the default "*" tag name.  A marker
+// starting with a comma (e.g. ",inline") matches a tag qualifier rather
+// than the tag's name, which allows different conventions to be mixed
+// in one codebase.
+func WithTypeMarker(typ reflect.Type, marker string) Option {
+	return func(o *options) {
+		if o.Markers == nil {
+			o.Markers = map[string]string{}
+		}
+		o.Markers[typeName(reflect2.Type2(typ))] = marker
+	}
+}
+
+// WithCaseInsensitivity sets whether keys are matched to the fields of
+// the passed struct type without regard to case, overriding the API-wide
+// default (which follows the API's CaseSensitive setting).  Keys that
+// differ from every field's name but for case are captured as additional
+// properties when case-insensitivity is disabled.  Exact matches always
+// take precedence.  See also WithCaseSensitivity.
+func WithCaseInsensitivity(typ reflect.Type, insensitive bool) Option {
+	return func(o *options) {
+		if o.CaseInsensitive == nil {
+			o.CaseInsensitive = map[string]bool{}
+		}
+		o.CaseInsensitive[typeName(reflect2.Type2(typ))] = insensitive
+	}
+}
+
+// WithAPMapFactory sets the function which creates the AP map of the
+// passed struct type each time it's decoded, instead of the extension
+// making an empty map sized using previous decodes.  The function must
+// return a map of the AP field's exact type, which is checked each time
+// it's called, failing the decode with ErrUnsupportedAPType otherwise.
+// A nil map is replaced by an empty one.  It's called even if the object
+// decoded has no additional properties.  Values are decoded into the
+// map as they are into one the extension creates, so a map[string]int
+// sink is populated using jsoniter's int decoder.
+func WithAPMapFactory(typ reflect.Type, factory func() interface{}) Option {
+	return func(o *options) {
+		if o.Maps == nil {
+			o.Maps = map[string]func() interface{}{}
+		}
+		o.Maps[typeName(reflect2.Type2(typ))] = factory
+	}
+}
+
+// WithStreamingDecode passes each additional property to fn as it's
+// decoded instead of collecting it into the AP map, which lets very
+// large objects be processed incrementally.  The iterator is positioned
+// at the property's value, which fn must consume exactly (e.g. using
+// ReadVal, Skip or SkipAndReturnBytes) and can fail the decode using
+// ReportError.  AP maps are left untouched while streaming, and the
+// key has already been transformed by WithKeyNormalizer.
+func WithStreamingDecode(fn func(key string, iter *jsoniter.Iterator)) Option {
+	return func(o *options) {
+		o.Stream = fn
+	}
+}
+
+// WithIgnoredKeys drops the passed keys when decoding the passed struct
+// type, rather than capturing them as additional properties, which keeps
+// known-garbage (e.g. legacy) keys out of the AP map.  Keys are matched
+// after WithKeyPreprocessor is applied, and keys that match one of the
+// struct's fields are still decoded into the field.
+func WithIgnoredKeys(typ reflect.Type, keys ...string) Option {
+	return func(o *options) {
+		if o.Ignored == nil {
+			o.Ignored = map[string]map[string]bool{}
+		}
+		name := typeName(reflect2.Type2(typ))
+		if o.Ignored[name] == nil {
+			o.Ignored[name] = map[string]bool{}
+		}
+		for _, key := range keys {
+			o.Ignored[name][key] = true
+		}
+	}
+}
+
+// ErrTooManyProperties is returned when decoding an object with more
+// additional properties than allowed by WithPropertyLimit.
+var ErrTooManyProperties = errors.New("too many additional properties")
+
+// LimitBehavior selects how the additional properties beyond the limit
+// set by WithPropertyLimit are handled.
+type LimitBehavior int
+
+const (
+	// SkipExcess drops the additional properties beyond the limit.
+	SkipExcess LimitBehavior = iota
+	// RejectExcess fails decoding with ErrTooManyProperties.
+	RejectExcess
+)
+
+// WithPropertyLimit caps the number of additional properties collected
+// from each decoded object, which bounds the memory used by payloads with
+// huge numbers of unknown keys.  Keys matching the struct's fields and
+// those dropped by WithIgnoredKeys don't count towards the limit.  By
+// default, the number of additional properties is unlimited.  It can
+// be combined with WithPropertyThreshold, whose threshold should then be
+// lower than the limit when RejectExcess is used.
+func WithPropertyLimit(max int, behavior LimitBehavior) Option {
+	return func(o *options) {
+		o.Limit = max
+		o.LimitBehavior = behavior
+	}
+}
+
+// InvalidValueBehavior selects how additional properties whose values
+// can't be decoded into the element type of a typed AP map (e.g. a
+// string where a map[string]int expects an int) are handled.
+type InvalidValueBehavior int
+
+const (
+	// RejectInvalid fails decoding with an error identifying the key.
+	RejectInvalid InvalidValueBehavior = iota
+	// SkipInvalid drops the additional property, and decodes the rest of
+	// the object.
+	SkipInvalid
+)
+
+// WithInvalidValues sets how values that can't be decoded into a typed AP
+// map are handled, which is RejectInvalid by default.  AP maps holding
+// raw JSON values accept any valid JSON, so they're unaffected.
+func WithInvalidValues(behavior InvalidValueBehavior) Option {
+	return func(o *options) {
+		o.Invalid = behavior
+	}
+}
+
+// WithInvalidValueField names the field of the passed struct type (which
+// must be a map[string]json.RawMessage, usually tagged "-") that receives
+// the raw JSON of the additional properties that can't be decoded into
+// its typed AP map.  Decoding the type is lenient, as with SkipInvalid,
+// whatever WithInvalidValues sets, and the field is replaced by each
+// decode: it's nil unless a value was invalid.
+func WithInvalidValueField(typ reflect.Type, field string) Option {
+	return func(o *options) {
+		if o.InvalidFields == nil {
+			o.InvalidFields = map[string]string{}
+		}
+		o.InvalidFields[typeName(reflect2.Type2(typ))] = field
+	}
+}
+
+// ErrThresholdExceeded is returned when decoding an object with more
+// additional properties than the threshold set by WithPropertyThreshold.
+var ErrThresholdExceeded = errors.New("additional-properties threshold exceeded")
+
+// WithPropertyThreshold fails decoding, with ErrThresholdExceeded, when
+// an object has more than max additional properties, which flags schema
+// drift while tolerating a few unexpected keys.  Unlike RejectExcess, the
+// whole object is decoded first, so every captured property is still
+// available in the decoded struct.  Keys beyond a WithPropertyLimit
+// limit (which are skipped rather than captured) are counted towards the
+// threshold, while those dropped by WithIgnoredKeys aren't.
+func WithPropertyThreshold(max int) Option {
+	return func(o *options) {
+		o.Threshold = max
+	}
+}
+
+// marker returns the AP field marker for the named type.
+func (o *options) marker(typ string) string {
+	if m, ok := o.Markers[typ]; ok {
+		return m
+	}
+	return o.Wildcard
+}
+
+// omitKey determines whether the additional property with the passed key
+// is omitted when encoding.
+func (o *options) omitKey(key string) bool {
+	for _, fn := range o.OmitKeys {
+		if fn(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// foldCase determines whether keys are matched to the fields of the
+// named type without regard to case.
+func (o *options) foldCase(typ string) bool {
+	if insensitive, ok := o.CaseInsensitive[typ]; ok {
+		return insensitive
+	}
+	return o.FoldCase
+}
+
+func (o *options) countPrefix(counts map[string]int, key string) {
+	for _, prefix := range o.Prefixes {
+		if strings.HasPrefix(key, prefix) {
+			counts[prefix]++
+			return
+		}
+	}
+}
@@ -0,0 +1,767 @@
+package ap_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/PennState/additional-properties/pkg/ap"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeStatsPrefixCounts(t *testing.T) {
+	var stats []ap.DecodeStats
+	json := ap.RegisterAdditionalPropertiesExtension(
+		jsoniter.Config{}.Froze(),
+		ap.WithDecodeStats(func(s ap.DecodeStats) { stats = append(stats, s) }),
+		ap.WithPrefixCounts("x-", "internal-"),
+	)
+
+	data := []byte(`{"fieldA":"Field A","x-one":1,"x-two":2,"internal-id":3,"other":4}`)
+	var s Simple
+	require.NoError(t, json.Unmarshal(data, &s))
+
+	require.Len(t, stats, 1)
+	assert.Equal(t, "ap_test.Simple", stats[0].Type)
+	assert.Equal(t, 4, stats[0].AdditionalProperties)
+	assert.Equal(t, map[string]int{"x-": 2, "internal-": 1}, stats[0].PrefixCounts)
+}
+
+func TestCapturedKeys(t *testing.T) {
+	var mu sync.Mutex
+	var keys [][]string
+	json := ap.RegisterAdditionalPropertiesExtension(
+		jsoniter.Config{}.Froze(),
+		ap.WithDecodeStats(func(s ap.DecodeStats) {
+			mu.Lock()
+			defer mu.Unlock()
+			keys = append(keys, s.Keys)
+		}),
+		ap.WithCapturedKeys(),
+	)
+
+	data := []byte(`{"fieldA":"Field A","x-one":1,"x-two":2}`)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var s Simple
+			assert.NoError(t, json.Unmarshal(data, &s))
+			assert.Len(t, s.AP, 2)
+		}()
+	}
+	wg.Wait()
+
+	require.Len(t, keys, 8)
+	for _, k := range keys {
+		assert.Equal(t, []string{"x-one", "x-two"}, k)
+	}
+}
+
+func TestValueInterning(t *testing.T) {
+	json := ap.RegisterAdditionalPropertiesExtension(
+		jsoniter.Config{}.Froze(),
+		ap.WithValueInterning(),
+	)
+
+	data := []byte(`{"fieldA":"Field A","fieldB":"same","fieldC":"same","fieldD":null}`)
+	var first, second Simple
+	require.NoError(t, json.Unmarshal(data, &first))
+	require.NoError(t, json.Unmarshal(data, &second))
+
+	assert.Equal(t, "\"same\"", string(first.AP["fieldB"]))
+	assert.Nil(t, first.AP["fieldD"])
+	assert.Same(t, &first.AP["fieldB"][0], &first.AP["fieldC"][0])
+	assert.Same(t, &first.AP["fieldB"][0], &second.AP["fieldB"][0])
+}
+
+func TestKeyPreprocessor(t *testing.T) {
+	json := ap.RegisterAdditionalPropertiesExtension(
+		jsoniter.Config{}.Froze(),
+		ap.WithKeyPreprocessor(func(key string) string {
+			return strings.TrimPrefix(key, "api_")
+		}),
+	)
+
+	data := []byte(`{"api_fieldA":"Field A","api_fieldB":"Field B","fieldC":"Field C"}`)
+	var s Simple
+	require.NoError(t, json.Unmarshal(data, &s))
+	assert.Equal(t, NewTestSimple(), &s)
+}
+
+func TestKeyNormalizer(t *testing.T) {
+	data := []byte(`{"FieldA":"Field A","Foo_Bar":"Foo Bar"}`)
+
+	var s Simple
+	require.NoError(t, ap.ConfigCompatibleWithStandardLibrary.Unmarshal(data, &s))
+	assert.Equal(t, "Field A", s.FieldA)
+	assert.Contains(t, s.AP, "Foo_Bar")
+
+	json := ap.RegisterAdditionalPropertiesExtension(
+		jsoniter.Config{}.Froze(),
+		ap.WithKeyNormalizer(strings.ToLower),
+	)
+	s = Simple{}
+	require.NoError(t, json.Unmarshal(data, &s))
+	assert.Equal(t, "Field A", s.FieldA)
+	require.Contains(t, s.AP, "foo_bar")
+	assert.Equal(t, `"Foo Bar"`, string(s.AP["foo_bar"]))
+	assert.Len(t, s.AP, 1)
+}
+
+func TestAbsentMarkers(t *testing.T) {
+	json := ap.RegisterAdditionalPropertiesExtension(
+		jsoniter.Config{}.Froze(),
+		ap.WithAbsentMarkers("undefined"),
+	)
+
+	data := []byte(`{"fieldA":"Field A","fieldB":"undefined","fieldC":"defined","fieldD":null}`)
+	var s Simple
+	require.NoError(t, json.Unmarshal(data, &s))
+	require.Len(t, s.AP, 3)
+	assert.Nil(t, s.AP["fieldB"])
+	assert.Equal(t, `"defined"`, string(s.AP["fieldC"]))
+	assert.Nil(t, s.AP["fieldD"])
+
+	// Typed AP maps hold the zero value, while other strings are decoded
+	// as usual.
+	var d Dynamic
+	require.NoError(t, json.Unmarshal(data, &d))
+	assert.Equal(t, Dynamic{
+		FieldA: "Field A",
+		AP:     map[string]interface{}{"fieldB": nil, "fieldC": "defined", "fieldD": nil},
+	}, d)
+
+	var c Counters
+	require.NoError(t, json.Unmarshal([]byte(`{"name":"n","a":1,"b":"undefined"}`), &c))
+	assert.Equal(t, Counters{Name: "n", AP: map[string]int{"a": 1, "b": 0}}, c)
+	assert.Error(t, json.Unmarshal([]byte(`{"name":"n","b":"defined"}`), &c))
+}
+
+// Dynamic has an AP map of arbitrary values.
+type Dynamic struct {
+	FieldA string                 `json:"fieldA"`
+	AP     map[string]interface{} `json:"*"`
+}
+
+// Inline marks its AP field with an "inline" tag qualifier.
+type Inline struct {
+	FieldA string                     `json:"fieldA"`
+	Extra  map[string]json.RawMessage `json:",inline"`
+}
+
+func TestTypeMarker(t *testing.T) {
+	json := ap.RegisterAdditionalPropertiesExtension(
+		jsoniter.Config{}.Froze(),
+		ap.WithTypeMarker(reflect.TypeOf(Inline{}), ",inline"),
+	)
+
+	data := []byte(`{"fieldA":"Field A","fieldB":"Field B","fieldC":"Field C"}`)
+
+	var s Simple
+	require.NoError(t, json.Unmarshal(data, &s))
+	assert.Equal(t, NewTestSimple(), &s)
+
+	var i Inline
+	require.NoError(t, json.Unmarshal(data, &i))
+	assert.Equal(t, "Field A", i.FieldA)
+	assert.Equal(t, s.AP, i.Extra)
+
+	actual, err := json.Marshal(i)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(data), string(actual))
+}
+
+func TestDeterministicOutput(t *testing.T) {
+	json := ap.RegisterAdditionalPropertiesExtension(
+		jsoniter.Config{}.Froze(),
+		ap.WithDeterministicOutput(),
+	)
+
+	outer := NewTestOuter()
+	readings := NewTestReadings()
+	for i := 0; i < 20; i++ {
+		actual, err := json.Marshal(outer)
+		require.NoError(t, err)
+		assert.Equal(t, `{"fieldA":"Field A","fieldD":"Field D","fieldB":"Field B","fieldC":"Field C"}`, string(actual))
+
+		actual, err = json.Marshal(readings)
+		require.NoError(t, err)
+		assert.Equal(t, `{"station":"North","evening":"8.0C","morning":"12.5C"}`, string(actual))
+	}
+}
+
+func TestIgnoredKeys(t *testing.T) {
+	json := ap.RegisterAdditionalPropertiesExtension(
+		jsoniter.Config{}.Froze(),
+		ap.WithIgnoredKeys(reflect.TypeOf(Simple{}), "legacy", "fieldA"),
+	)
+
+	data := []byte(`{"fieldA":"Field A","legacy":{"nested":[1,2]},"fieldB":"Field B","fieldC":"Field C"}`)
+
+	var s Simple
+	require.NoError(t, json.Unmarshal(data, &s))
+	assert.Equal(t, NewTestSimple(), &s)
+
+	var o Outer
+	require.NoError(t, json.Unmarshal(data, &o))
+	assert.Contains(t, o.AP, "legacy")
+}
+
+func TestStreamingDecode(t *testing.T) {
+	streamed := map[string]string{}
+	json := ap.RegisterAdditionalPropertiesExtension(
+		jsoniter.Config{}.Froze(),
+		ap.WithStreamingDecode(func(key string, iter *jsoniter.Iterator) {
+			streamed[key] = string(iter.SkipAndReturnBytes())
+		}),
+	)
+
+	data := []byte(`{"fieldA":"Field A","fieldB":"Field B","fieldC":{"nested":[1,2]}}`)
+
+	var s Simple
+	require.NoError(t, json.Unmarshal(data, &s))
+	assert.Equal(t, "Field A", s.FieldA)
+	assert.Nil(t, s.AP)
+	assert.Equal(t, map[string]string{
+		"fieldB": `"Field B"`,
+		"fieldC": `{"nested":[1,2]}`,
+	}, streamed)
+}
+
+func TestStreamingDecodeError(t *testing.T) {
+	json := ap.RegisterAdditionalPropertiesExtension(
+		jsoniter.Config{}.Froze(),
+		ap.WithStreamingDecode(func(key string, iter *jsoniter.Iterator) {
+			iter.ReportError("stream", "rejected")
+		}),
+	)
+
+	var s Simple
+	err := json.Unmarshal([]byte(`{"fieldA":"Field A","fieldB":"Field B"}`), &s)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `error decoding additional property "fieldB" of ap_test.Simple: `)
+}
+
+func TestPropertyLimit(t *testing.T) {
+	data := []byte(`{"fieldA":"Field A","fieldB":"Field B","fieldC":"Field C","fieldD":"Field D"}`)
+
+	t.Run("Skip", func(t *testing.T) {
+		json := ap.RegisterAdditionalPropertiesExtension(
+			jsoniter.Config{}.Froze(),
+			ap.WithPropertyLimit(2, ap.SkipExcess),
+		)
+		var s Simple
+		require.NoError(t, json.Unmarshal(data, &s))
+		assert.Equal(t, NewTestSimple(), &s)
+	})
+
+	t.Run("Reject", func(t *testing.T) {
+		json := ap.RegisterAdditionalPropertiesExtension(
+			jsoniter.Config{}.Froze(),
+			ap.WithPropertyLimit(2, ap.RejectExcess),
+		)
+		var s Simple
+		err := json.Unmarshal(data, &s)
+		assert.ErrorIs(t, err, ap.ErrTooManyProperties)
+
+		// Objects within the limit are unaffected
+		s = Simple{}
+		require.NoError(t, json.Unmarshal([]byte(`{"fieldA":"Field A","fieldB":"Field B","fieldC":"Field C"}`), &s))
+		assert.Equal(t, NewTestSimple(), &s)
+	})
+}
+
+// Tallies has a typed AP map, and a field for the additional properties
+// that aren't ints.
+type Tallies struct {
+	Name    string                     `json:"name"`
+	AP      map[string]int             `json:"*"`
+	Invalid map[string]json.RawMessage `json:"-"`
+}
+
+func TestInvalidValues(t *testing.T) {
+	data := []byte(`{"name":"t","a":1,"b":"two","c":{"n":3},"d":4,"e":1e400}`)
+
+	t.Run("Reject", func(t *testing.T) {
+		api := ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze())
+		var v Tallies
+		err := api.Unmarshal(data, &v)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `error decoding additional property "b" of ap_test.Tallies: `)
+	})
+
+	t.Run("Skip", func(t *testing.T) {
+		api := ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze(), ap.WithInvalidValues(ap.SkipInvalid))
+		var v Tallies
+		require.NoError(t, api.Unmarshal(data, &v))
+		assert.Equal(t, Tallies{Name: "t", AP: map[string]int{"a": 1, "d": 4}}, v)
+
+		// Invalid JSON still fails the decode.
+		assert.Error(t, api.Unmarshal([]byte(`{"a":1,"b":tw}`), &v))
+	})
+
+	t.Run("Field", func(t *testing.T) {
+		api := ap.RegisterAdditionalPropertiesExtension(
+			jsoniter.Config{}.Froze(),
+			ap.WithInvalidValueField(reflect.TypeOf(Tallies{}), "Invalid"),
+		)
+		var v Tallies
+		require.NoError(t, api.Unmarshal(data, &v))
+		assert.Equal(t, Tallies{
+			Name: "t",
+			AP:   map[string]int{"a": 1, "d": 4},
+			Invalid: map[string]json.RawMessage{
+				"b": json.RawMessage(`"two"`),
+				"c": json.RawMessage(`{"n":3}`),
+				"e": json.RawMessage(`1e400`),
+			},
+		}, v)
+
+		// Each decode replaces the field.
+		require.NoError(t, api.Unmarshal([]byte(`{"a":1}`), &v))
+		assert.Nil(t, v.Invalid)
+	})
+
+	t.Run("Misconfigured field", func(t *testing.T) {
+		for _, field := range []string{"Missing", "Name"} {
+			api := ap.RegisterAdditionalPropertiesExtension(
+				jsoniter.Config{}.Froze(),
+				ap.WithInvalidValueField(reflect.TypeOf(Tallies{}), field),
+			)
+			var v Tallies
+			assert.ErrorIs(t, api.Unmarshal([]byte(`{"a":1}`), &v), ap.ErrInvalidValueField, field)
+		}
+	})
+}
+
+func TestWithoutNulls(t *testing.T) {
+	v := APOnly{
+		AP: map[string]json.RawMessage{
+			"a":      json.RawMessage(`1`),
+			"nil":    nil,
+			"null":   json.RawMessage(`null`),
+			"padded": json.RawMessage(" null\n"),
+			"quoted": json.RawMessage(`"null"`),
+		},
+	}
+
+	api := ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze(), ap.WithDeterministicOutput())
+	actual, err := api.Marshal(v)
+	require.NoError(t, err)
+	assert.Equal(t, "{\"a\":1,\"nil\":null,\"null\":null,\"padded\": null\n,\"quoted\":\"null\"}", string(actual))
+
+	api = ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze(), ap.WithDeterministicOutput(), ap.WithoutNulls())
+	actual, err = api.Marshal(v)
+	require.NoError(t, err)
+	assert.Equal(t, `{"a":1,"quoted":"null"}`, string(actual))
+
+	actual, err = api.Marshal(APOnly{AP: map[string]json.RawMessage{"null": nil}})
+	require.NoError(t, err)
+	assert.Equal(t, `{}`, string(actual))
+
+	// Only JSON whitespace surrounds a null, not other Unicode spaces.
+	actual, err = api.Marshal(APOnly{AP: map[string]json.RawMessage{"nbsp": json.RawMessage("\u00a0null")}})
+	require.NoError(t, err)
+	assert.Equal(t, "{\"nbsp\":\u00a0null}", string(actual))
+}
+
+func TestOmitPredicate(t *testing.T) {
+	v := APOnly{
+		AP: map[string]json.RawMessage{
+			"array":  json.RawMessage(`[]`),
+			"object": json.RawMessage(`{}`),
+			"string": json.RawMessage(`""`),
+			"kept":   json.RawMessage(`"value"`),
+			"secret": json.RawMessage(`"hunter2"`),
+		},
+	}
+	empty := func(key string, raw json.RawMessage) bool {
+		switch string(raw) {
+		case `[]`, `{}`, `""`:
+			return true
+		}
+		return key == "secret"
+	}
+
+	api := ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze(), ap.WithDeterministicOutput(), ap.WithOmitPredicate(empty))
+	actual, err := api.Marshal(v)
+	require.NoError(t, err)
+	assert.Equal(t, `{"kept":"value"}`, string(actual))
+
+	// Decoding is unaffected.
+	var decoded APOnly
+	require.NoError(t, api.Unmarshal([]byte(`{"array":[],"kept":"value"}`), &decoded))
+	assert.Len(t, decoded.AP, 2)
+}
+
+func TestOmittedKeys(t *testing.T) {
+	v := APOnly{
+		AP: map[string]json.RawMessage{
+			"_internal_id": json.RawMessage(`1`),
+			"_internal":    json.RawMessage(`2`),
+			"ssn":          json.RawMessage(`"123-45-6789"`),
+			"user_ssn":     json.RawMessage(`"987-65-4321"`),
+			"kept":         json.RawMessage(`"value"`),
+		},
+	}
+	internal := func(key string) bool { return strings.HasPrefix(key, "_internal_") }
+
+	t.Run("Prefix", func(t *testing.T) {
+		api := ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze(), ap.WithDeterministicOutput(), ap.WithOmittedKeys(internal))
+		actual, err := api.Marshal(v)
+		require.NoError(t, err)
+		assert.Equal(t, `{"_internal":2,"kept":"value","ssn":"123-45-6789","user_ssn":"987-65-4321"}`, string(actual))
+	})
+
+	t.Run("Pattern", func(t *testing.T) {
+		api := ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze(), ap.WithDeterministicOutput(), ap.WithOmittedKeyPattern(regexp.MustCompile(`(^|_)ssn$`)))
+		actual, err := api.Marshal(v)
+		require.NoError(t, err)
+		assert.Equal(t, `{"_internal":2,"_internal_id":1,"kept":"value"}`, string(actual))
+	})
+
+	t.Run("Combined", func(t *testing.T) {
+		api := ap.RegisterAdditionalPropertiesExtension(
+			jsoniter.Config{}.Froze(),
+			ap.WithDeterministicOutput(),
+			ap.WithOmittedKeys(internal),
+			ap.WithOmittedKeyPattern(regexp.MustCompile(`ssn`)),
+		)
+		actual, err := api.Marshal(v)
+		require.NoError(t, err)
+		assert.Equal(t, `{"_internal":2,"kept":"value"}`, string(actual))
+
+		// Typed AP maps are filtered too, and decoding is unaffected.
+		actual, err = api.Marshal(Counters{Name: "c", AP: map[string]int{"_internal_hits": 1, "hits": 2}})
+		require.NoError(t, err)
+		assert.Equal(t, `{"name":"c","hits":2}`, string(actual))
+		var decoded APOnly
+		require.NoError(t, api.Unmarshal([]byte(`{"_internal_id":1,"ssn":"x"}`), &decoded))
+		assert.Len(t, decoded.AP, 2)
+	})
+}
+
+func TestStandardFloats(t *testing.T) {
+	v := struct {
+		F float64 `json:"f"`
+	}{1e-7}
+
+	// Without the option, the API's float encoders are left alone.
+	actual, err := ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze()).Marshal(v)
+	require.NoError(t, err)
+	assert.Equal(t, `{"f":1e-07}`, string(actual))
+
+	actual, err = ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze(), ap.WithStandardFloats()).Marshal(v)
+	require.NoError(t, err)
+	assert.Equal(t, `{"f":1e-7}`, string(actual))
+}
+
+func TestCanonicalValues(t *testing.T) {
+	v := APOnly{
+		AP: map[string]json.RawMessage{
+			"nested": json.RawMessage(`{ "z": 1, "a": {"y": [1, 2.50], "b": "<b>"} }`),
+			"big":    json.RawMessage(`12345678901234567890`),
+			"nil":    nil,
+		},
+	}
+
+	api := ap.RegisterAdditionalPropertiesExtension(
+		jsoniter.Config{SortMapKeys: true}.Froze(),
+		ap.WithDeterministicOutput(),
+	)
+	actual, err := api.Marshal(v)
+	require.NoError(t, err)
+	assert.Equal(t, `{"big":12345678901234567890,"nested":{ "z": 1, "a": {"y": [1, 2.50], "b": "<b>"} },"nil":null}`, string(actual))
+
+	api = ap.RegisterAdditionalPropertiesExtension(
+		jsoniter.Config{SortMapKeys: true}.Froze(),
+		ap.WithDeterministicOutput(),
+		ap.WithCanonicalValues(),
+	)
+	actual, err = api.Marshal(v)
+	require.NoError(t, err)
+	assert.Equal(t, `{"big":12345678901234567890,"nested":{"a":{"b":"<b>","y":[1,2.50]},"z":1},"nil":null}`, string(actual))
+
+	// Invalid values, including those jsoniter would decode, are written
+	// verbatim.
+	for _, raw := range []string{`01`, `{"b": 1.}`, `[1] ]`, `{"a":1,}`} {
+		actual, err = api.Marshal(APOnly{AP: map[string]json.RawMessage{"bad": json.RawMessage(raw)}})
+		require.NoError(t, err)
+		assert.Equal(t, `{"bad":`+raw+`}`, string(actual))
+	}
+
+	// Nested structs' AP maps are canonicalized too, and strings are
+	// escaped as the API escapes them.
+	api = ap.RegisterAdditionalPropertiesExtension(
+		jsoniter.Config{SortMapKeys: true, EscapeHTML: true}.Froze(),
+		ap.WithDeterministicOutput(),
+		ap.WithCanonicalValues(),
+	)
+	actual, err = api.Marshal(Parent{FieldP: "Field P", Child: &Simple{AP: v.AP}})
+	require.NoError(t, err)
+	assert.Equal(t, `{"fieldP":"Field P","child":{"fieldA":"","big":12345678901234567890,"nested":{"a":{"b":"\u003cb\u003e","y":[1,2.50]},"z":1},"nil":null}}`, string(actual))
+}
+
+func TestStats(t *testing.T) {
+	api := ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze(), ap.WithStats())
+	data := []byte(`{"fieldA":"Field A","fieldB":"Field B","fieldC":"Field C"}`)
+	for i := 0; i < 3; i++ {
+		var s Simple
+		require.NoError(t, api.Unmarshal(data, &s))
+		_, err := api.Marshal(s)
+		require.NoError(t, err)
+	}
+	var n NoAP
+	require.NoError(t, api.Unmarshal(data, &n))
+
+	stats, ok := ap.StatsOf(api)
+	require.True(t, ok)
+	assert.Equal(t, int64(1), stats.DecoratedDecoders)
+	assert.Equal(t, int64(1), stats.DecoratedEncoders)
+	assert.Equal(t, int64(3), stats.DescHits)
+	assert.Equal(t, int64(0), stats.DescMisses)
+	assert.Equal(t, int64(2), stats.APBindingHits)
+	assert.Equal(t, int64(1), stats.APBindingMisses)
+	assert.Equal(t, int64(6), stats.AdditionalProperties)
+
+	_, ok = ap.StatsOf(ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze()))
+	assert.False(t, ok)
+	_, ok = ap.StatsOf(jsoniter.Config{}.Froze())
+	assert.False(t, ok)
+}
+
+// Legacy is decoded case-insensitively regardless of the API's setting.
+type Legacy struct {
+	FieldA string                     `json:"fieldA"`
+	AP     map[string]json.RawMessage `json:"*"`
+}
+
+func TestCaseInsensitivity(t *testing.T) {
+	data := []byte(`{"FIELDA":"Field A","fieldB":"Field B"}`)
+	decode := func(api jsoniter.API) (Simple, Legacy) {
+		var s Simple
+		require.NoError(t, api.Unmarshal(data, &s))
+		var l Legacy
+		require.NoError(t, api.Unmarshal(data, &l))
+		return s, l
+	}
+	folded := func(t *testing.T, fieldA string, ap map[string]json.RawMessage) {
+		assert.Equal(t, "Field A", fieldA)
+		assert.Equal(t, map[string]json.RawMessage{"fieldB": json.RawMessage(`"Field B"`)}, ap)
+	}
+	exact := func(t *testing.T, fieldA string, ap map[string]json.RawMessage) {
+		assert.Empty(t, fieldA)
+		assert.Equal(t, map[string]json.RawMessage{
+			"FIELDA": json.RawMessage(`"Field A"`),
+			"fieldB": json.RawMessage(`"Field B"`),
+		}, ap)
+	}
+
+	t.Run("API default", func(t *testing.T) {
+		s, l := decode(ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze()))
+		folded(t, s.FieldA, s.AP)
+		folded(t, l.FieldA, l.AP)
+
+		s, l = decode(ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{CaseSensitive: true}.Froze()))
+		exact(t, s.FieldA, s.AP)
+		exact(t, l.FieldA, l.AP)
+	})
+
+	t.Run("Per type", func(t *testing.T) {
+		s, l := decode(ap.RegisterAdditionalPropertiesExtension(
+			jsoniter.Config{CaseSensitive: true}.Froze(),
+			ap.WithCaseInsensitivity(reflect.TypeOf(Legacy{}), true),
+		))
+		exact(t, s.FieldA, s.AP)
+		folded(t, l.FieldA, l.AP)
+
+		s, l = decode(ap.RegisterAdditionalPropertiesExtension(
+			jsoniter.Config{}.Froze(),
+			ap.WithCaseInsensitivity(reflect.TypeOf(Simple{}), false),
+		))
+		exact(t, s.FieldA, s.AP)
+		folded(t, l.FieldA, l.AP)
+	})
+}
+
+func TestPropertyThreshold(t *testing.T) {
+	data := []byte(`{"fieldA":"Field A","fieldB":"Field B","fieldC":"Field C","fieldD":"Field D"}`)
+
+	api := ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze(), ap.WithPropertyThreshold(2))
+	var s Simple
+	err := api.Unmarshal(data, &s)
+	require.ErrorIs(t, err, ap.ErrThresholdExceeded)
+	assert.Contains(t, err.Error(), "ap_test.Simple has 3, more than 2")
+	assert.Equal(t, "Field A", s.FieldA)
+	assert.Len(t, s.AP, 3)
+
+	// Objects within the threshold are unaffected.
+	s = Simple{}
+	require.NoError(t, api.Unmarshal([]byte(`{"fieldA":"Field A","fieldB":"Field B","fieldC":"Field C"}`), &s))
+	assert.Equal(t, NewTestSimple(), &s)
+
+	// Skipped keys count towards the threshold, while ignored keys don't.
+	api = ap.RegisterAdditionalPropertiesExtension(
+		jsoniter.Config{}.Froze(),
+		ap.WithPropertyThreshold(2),
+		ap.WithPropertyLimit(1, ap.SkipExcess),
+	)
+	s = Simple{}
+	require.ErrorIs(t, api.Unmarshal(data, &s), ap.ErrThresholdExceeded)
+	assert.Len(t, s.AP, 1)
+
+	api = ap.RegisterAdditionalPropertiesExtension(
+		jsoniter.Config{}.Froze(),
+		ap.WithPropertyThreshold(2),
+		ap.WithIgnoredKeys(reflect.TypeOf(Simple{}), "fieldD"),
+	)
+	s = Simple{}
+	require.NoError(t, api.Unmarshal(data, &s))
+	assert.Equal(t, NewTestSimple(), &s)
+}
+
+// Tagged uses a custom wildcard tag.
+type Tagged struct {
+	FieldA string                     `json:"fieldA"`
+	Extra  map[string]json.RawMessage `json:"-extra"`
+}
+
+// recordingLogger records the warnings and errors logged to it.
+type recordingLogger struct {
+	Messages []string
+}
+
+func (l *recordingLogger) Trace(...interface{}) {}
+func (l *recordingLogger) Debug(...interface{}) {}
+func (l *recordingLogger) Warn(args ...interface{}) {
+	l.Messages = append(l.Messages, fmt.Sprint(args...))
+}
+func (l *recordingLogger) Error(args ...interface{}) {
+	l.Messages = append(l.Messages, fmt.Sprint(args...))
+}
+
+func TestRegistrationOptions(t *testing.T) {
+	t.Run("Field order", func(t *testing.T) {
+		api := ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze(), ap.WithFieldOrder())
+		for i := 0; i < 10; i++ {
+			actual, err := api.Marshal(Identifiers{Upper: "a", Lower: "b", Mixed: "c", Other: "d"})
+			require.NoError(t, err)
+			assert.Equal(t, `{"ID":"a","id":"b","Key":"c","KEY":"d"}`, string(actual))
+		}
+	})
+
+	t.Run("Sorted additional properties", func(t *testing.T) {
+		api := ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze(), ap.WithSortedAdditionalProperties())
+		v := APOnly{AP: map[string]json.RawMessage{}}
+		for _, k := range []string{"d", "b", "a", "c", "e"} {
+			v.AP[k] = json.RawMessage(`1`)
+		}
+		for i := 0; i < 10; i++ {
+			actual, err := api.Marshal(v)
+			require.NoError(t, err)
+			assert.Equal(t, `{"a":1,"b":1,"c":1,"d":1,"e":1}`, string(actual))
+		}
+	})
+
+	t.Run("Case sensitivity", func(t *testing.T) {
+		data := []byte(`{"FIELDA":"Field A"}`)
+		var s Simple
+		api := ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze(), ap.WithCaseSensitivity(true))
+		require.NoError(t, api.Unmarshal(data, &s))
+		assert.Empty(t, s.FieldA)
+		assert.Contains(t, s.AP, "FIELDA")
+
+		s = Simple{}
+		api = ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{CaseSensitive: true}.Froze(), ap.WithCaseSensitivity(false))
+		require.NoError(t, api.Unmarshal(data, &s))
+		assert.Equal(t, "Field A", s.FieldA)
+	})
+
+	t.Run("Logger", func(t *testing.T) {
+		logger := &recordingLogger{}
+		api := ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze(), ap.WithLogger(logger))
+		_, err := api.Marshal(NotAMap{})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Ignoring wildcard field - not a map with string keys: AP"}, logger.Messages)
+	})
+
+	t.Run("Wildcard tag", func(t *testing.T) {
+		api := ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze(), ap.WithWildcardTag("-extra"))
+		data := []byte(`{"fieldA":"Field A","fieldB":"Field B"}`)
+		var v Tagged
+		require.NoError(t, api.Unmarshal(data, &v))
+		assert.Equal(t, Tagged{FieldA: "Field A", Extra: map[string]json.RawMessage{"fieldB": json.RawMessage(`"Field B"`)}}, v)
+		actual, err := api.Marshal(v)
+		require.NoError(t, err)
+		assert.JSONEq(t, string(data), string(actual))
+	})
+}
+
+// Counters has an AP map of ints.
+type Counters struct {
+	Name string         `json:"name"`
+	AP   map[string]int `json:"*"`
+}
+
+func TestAPMapFactory(t *testing.T) {
+	var calls int
+	api := ap.RegisterAdditionalPropertiesExtension(
+		jsoniter.Config{}.Froze(),
+		ap.WithAPMapFactory(reflect.TypeOf(Counters{}), func() interface{} {
+			calls++
+			return make(map[string]int, 8)
+		}),
+		ap.WithAPMapFactory(reflect.TypeOf(Simple{}), func() interface{} {
+			return map[string]json.RawMessage{"default": json.RawMessage(`true`)}
+		}),
+	)
+
+	var c Counters
+	require.NoError(t, api.Unmarshal([]byte(`{"name":"n","a":1,"b":2}`), &c))
+	assert.Equal(t, Counters{Name: "n", AP: map[string]int{"a": 1, "b": 2}}, c)
+	assert.Equal(t, 1, calls)
+	require.NoError(t, api.Unmarshal([]byte(`{"name":"n"}`), &c))
+	assert.Equal(t, Counters{Name: "n", AP: map[string]int{}}, c)
+	assert.Equal(t, 2, calls)
+	assert.Error(t, api.Unmarshal([]byte(`{"a":"one"}`), &c))
+
+	// The map is created even without any additional properties, so
+	// defaults can be supplied.
+	var s Simple
+	require.NoError(t, api.Unmarshal([]byte(`{"fieldA":"Field A"}`), &s))
+	assert.Equal(t, map[string]json.RawMessage{"default": json.RawMessage(`true`)}, s.AP)
+	require.NoError(t, api.Unmarshal([]byte(`{"fieldB":"Field B"}`), &s))
+	assert.Equal(t, map[string]json.RawMessage{
+		"default": json.RawMessage(`true`),
+		"fieldB":  json.RawMessage(`"Field B"`),
+	}, s.AP)
+
+	mismatched := ap.RegisterAdditionalPropertiesExtension(
+		jsoniter.Config{}.Froze(),
+		ap.WithAPMapFactory(reflect.TypeOf(Counters{}), func() interface{} {
+			return map[string]int64{}
+		}),
+	)
+	err := mismatched.Unmarshal([]byte(`{"a":1}`), &c)
+	assert.ErrorIs(t, err, ap.ErrUnsupportedAPType)
+	assert.Contains(t, err.Error(), "factory of ap_test.Counters returns map[string]int64 rather than map[string]int")
+
+	// A nil map of the right type is replaced by an empty one.
+	nilMaps := ap.RegisterAdditionalPropertiesExtension(
+		jsoniter.Config{}.Froze(),
+		ap.WithAPMapFactory(reflect.TypeOf(Counters{}), func() interface{} {
+			return map[string]int(nil)
+		}),
+	)
+	c = Counters{}
+	require.NoError(t, nilMaps.Unmarshal([]byte(`{"name":"n","a":1}`), &c))
+	assert.Equal(t, Counters{Name: "n", AP: map[string]int{"a": 1}}, c)
+}
@@ -0,0 +1,156 @@
+package ap_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/PennState/additional-properties/pkg/ap"
+	"github.com/PennState/proctor/pkg/goldenfile"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Parent holds a struct with additional properties through a pointer.
+type Parent struct {
+	FieldP string  `json:"fieldP"`
+	Child  *Simple `json:"child"`
+}
+
+func NewZeroParent() interface{} {
+	return &Parent{}
+}
+
+func NewTestParent() interface{} {
+	child, _ := NewTestSimple().(*Simple)
+	return &Parent{
+		FieldP: "Field P",
+		Child:  child,
+	}
+}
+
+// TestTopLevelPointer decodes into a nil *Simple, which jsoniter has to
+// allocate before the decorated struct decoder runs.
+func TestTopLevelPointer(t *testing.T) {
+	json := ap.ConfigCompatibleWithStandardLibrary
+	data, err := ioutil.ReadFile(goldenfile.GetDefaultFilePath("simple.json"))
+	require.NoError(t, err)
+
+	var actual *Simple
+	require.NoError(t, json.Unmarshal(data, &actual))
+	assert.Equal(t, NewTestSimple(), actual)
+
+	out, err := json.Marshal(actual)
+	require.NoError(t, err)
+	goldenfile.AssertJSONEq(t, goldenfile.GetDefaultFilePath("simple.json"), string(out))
+}
+
+// TestNilPointer verifies that nil pointers to structs with additional
+// properties are written as null, including before the struct's encoder
+// has been built and with options that change how AP fields are written.
+func TestNilPointer(t *testing.T) {
+	apis := map[string]jsoniter.API{
+		"Default":       ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze()),
+		"Deterministic": ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze(), ap.WithDeterministicOutput()),
+		"Validated":     ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{ValidateJsonRawMessage: true}.Froze()),
+	}
+	for name, api := range apis {
+		api := api
+		t.Run(name, func(t *testing.T) {
+			actual, err := api.Marshal((*Simple)(nil))
+			require.NoError(t, err)
+			assert.Equal(t, "null", string(actual))
+
+			actual, err = api.Marshal(Parent{FieldP: "Field P"})
+			require.NoError(t, err)
+			assert.JSONEq(t, `{"fieldP":"Field P","child":null}`, string(actual))
+
+			actual, err = api.Marshal([]*Simple{nil, {FieldA: "Field A"}})
+			require.NoError(t, err)
+			assert.JSONEq(t, `[null,{"fieldA":"Field A"}]`, string(actual))
+
+			actual, err = api.Marshal(map[string]*Readings{"closed": nil})
+			require.NoError(t, err)
+			assert.JSONEq(t, `{"closed":null}`, string(actual))
+
+			// A struct with only an AP map is pointer-shaped, so jsoniter
+			// encodes its pointers without indirection.
+			actual, err = api.Marshal((*APOnly)(nil))
+			require.NoError(t, err)
+			assert.Equal(t, "null", string(actual))
+
+			actual, err = api.Marshal(map[string]interface{}{"child": (*Simple)(nil), "only": (*APOnly)(nil)})
+			require.NoError(t, err)
+			assert.JSONEq(t, `{"child":null,"only":null}`, string(actual))
+		})
+	}
+
+	actual, err := ap.Encode[*Simple](nil)
+	require.NoError(t, err)
+	assert.Equal(t, "null", string(actual))
+	actual, err = ap.MarshalWithoutAP(ap.ConfigCompatibleWithStandardLibrary, (*Simple)(nil))
+	require.NoError(t, err)
+	assert.Equal(t, "null", string(actual))
+}
+
+// Container holds structs with additional properties by value, after
+// another field so that they're at non-zero offsets.
+type Container struct {
+	FieldP string `json:"fieldP"`
+	First  Simple `json:"first"`
+	Second Simple `json:"second"`
+}
+
+func NewZeroContainer() interface{} {
+	return &Container{}
+}
+
+func NewTestContainer() interface{} {
+	first, _ := NewTestSimple().(*Simple)
+	return &Container{
+		FieldP: "Field P",
+		First:  *first,
+		Second: newTestSimpleElement("Field A2", "Field B2"),
+	}
+}
+
+// Profile and Account both have AP fields, with one nested in the other.
+type Profile struct {
+	Name string                     `json:"name"`
+	AP   map[string]json.RawMessage `json:"*"`
+}
+
+type Account struct {
+	ID      string                     `json:"id"`
+	Profile Profile                    `json:"profile"`
+	Backup  *Profile                   `json:"backup"`
+	AP      map[string]json.RawMessage `json:"*"`
+}
+
+// TestNestedAPField verifies that a named field whose type has its own
+// AP field is decoded by that type's decorated decoder, so the nested
+// additional properties are captured by the nested struct rather than
+// dropped or captured by the outer one.
+func TestNestedAPField(t *testing.T) {
+	api := ap.ConfigCompatibleWithStandardLibrary
+	data := []byte(`{"id":"1","profile":{"name":"n","theme":"dark"},"backup":{"name":"b","lang":"en"},"plan":"pro"}`)
+	var actual Account
+	require.NoError(t, api.Unmarshal(data, &actual))
+	assert.Equal(t, Account{
+		ID: "1",
+		Profile: Profile{
+			Name: "n",
+			AP:   map[string]json.RawMessage{"theme": json.RawMessage(`"dark"`)},
+		},
+		Backup: &Profile{
+			Name: "b",
+			AP:   map[string]json.RawMessage{"lang": json.RawMessage(`"en"`)},
+		},
+		AP: map[string]json.RawMessage{"plan": json.RawMessage(`"pro"`)},
+	}, actual)
+
+	out, err := api.Marshal(actual)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(data), string(out))
+}
@@ -0,0 +1,29 @@
+package ap_test
+
+import "encoding/json"
+
+// Label is a named primitive that can be embedded in a struct.
+type Label string
+
+// WithLabel embeds a non-struct type, which is encoded as a regular field
+// named after the type.
+type WithLabel struct {
+	Label
+	FieldA string                     `json:"fieldA"`
+	AP     map[string]json.RawMessage `json:"*"`
+}
+
+func NewZeroWithLabel() interface{} {
+	return &WithLabel{}
+}
+
+func NewTestWithLabel() interface{} {
+	return &WithLabel{
+		Label:  "Label",
+		FieldA: "Field A",
+		AP: map[string]json.RawMessage{
+			"fieldB": json.RawMessage([]byte("\"Field B\"")),
+			"fieldC": json.RawMessage([]byte("\"Field C\"")),
+		},
+	}
+}
@@ -0,0 +1,232 @@
+package ap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/modern-go/reflect2"
+)
+
+// ErrPropertyNotFound is returned when a requested additional property
+// doesn't exist.
+var ErrPropertyNotFound = errors.New("additional property not found")
+
+// ErrNoAdditionalProperties is returned when a value doesn't have an AP
+// field.
+var ErrNoAdditionalProperties = errors.New("no additional-properties field")
+
+// AdditionalProperties is a typed AP map.  The extension recognizes it
+// as the AP sink when it's either declared as a field with the wildcard
+// tag or embedded (without a tag) in a struct.
+//
+// Values are retained as raw JSON until they're accessed, so callers can
+// decode each property into a type chosen at runtime (e.g. based on a
+// sibling discriminator field) using Unmarshal or UnmarshalInto.
+// Decoding doesn't modify or cache the raw bytes.
+//
+// As with encoding/json, whitespace surrounding a value isn't captured
+// but whitespace within it is.  Like a named json.RawMessage field, the
+// value is then re-encoded verbatim, so MarshalJCS or MarshalIndent
+// should be used when normalized output is required.
+type AdditionalProperties map[string]json.RawMessage
+
+//nolint:gochecknoglobals
+var (
+	additionalPropertiesType = reflect2.TypeOf(AdditionalProperties{})
+	rawMapType               = reflect.TypeOf(map[string]json.RawMessage{})
+)
+
+// AdditionalPropertiesHolder can be implemented by structs with an AP
+// field to give AdditionalPropertiesOf direct access to it, without
+// reflection.  It doesn't affect how the extension locates the AP field.
+// The method isn't named AdditionalProperties since a struct embedding
+// AdditionalProperties couldn't declare it.
+type AdditionalPropertiesHolder interface {
+	APMap() map[string]json.RawMessage
+}
+
+// AdditionalPropertiesOf returns the AP map of v, which must be a struct
+// or a pointer to one, and whether v has one.  If v implements
+// AdditionalPropertiesHolder, its map is returned.  Otherwise, the AP
+// field is located using reflection, as the extension registered with
+// DefaultConfig does: a field with its wildcard tag (see WithWildcardTag
+// and WithTypeMarker) or an embedded AdditionalProperties, including one
+// promoted from an embedded struct.  Only AP maps holding raw JSON values
+// are returned, and the returned map shares its entries with v, except
+// that a map keyed by a defined string type, or holding a raw type other
+// than json.RawMessage, is copied.  A holder is always reported as having
+// an AP map, unless it's a nil pointer.
+func AdditionalPropertiesOf(v interface{}) (map[string]json.RawMessage, bool) {
+	val := reflect.ValueOf(v)
+	if val.Kind() == reflect.Ptr && val.IsNil() {
+		return nil, false
+	}
+	if h, ok := v.(AdditionalPropertiesHolder); ok {
+		return h.APMap(), true
+	}
+	for val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			return nil, false
+		}
+		val = val.Elem()
+	}
+	if val.Kind() != reflect.Struct {
+		return nil, false
+	}
+	fv, ok := additionalPropertiesOf(val, apOptions())
+	if !ok {
+		return nil, false
+	}
+	if fv.Type().ConvertibleTo(rawMapType) {
+		return fv.Convert(rawMapType).Interface().(map[string]json.RawMessage), true
+	}
+	if fv.IsNil() {
+		return nil, true
+	}
+	m := make(map[string]json.RawMessage, fv.Len())
+	iter := fv.MapRange()
+	for iter.Next() {
+		m[iter.Key().String()] = iter.Value().Convert(rawMapType.Elem()).Interface().(json.RawMessage)
+	}
+	return m, true
+}
+
+// SetAdditionalProperty marshals value using DefaultConfig and stores
+// it as the additional property named by key in the AP map of v, which
+// must be a non-nil pointer to a struct.  The AP field is located as for
+// AdditionalPropertiesOf, including by the default config's custom
+// markers, and the map is created if it's nil.
+// ErrNoAdditionalProperties is returned if v doesn't have an AP field.
+func SetAdditionalProperty(v interface{}, key string, value interface{}) error {
+	val := reflect.ValueOf(v)
+	if val.Kind() != reflect.Ptr || val.IsNil() || val.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("%w: %T isn't a non-nil pointer to a struct", ErrNoAdditionalProperties, v)
+	}
+	fv, ok := additionalPropertiesOf(val.Elem(), apOptions())
+	if !ok {
+		return fmt.Errorf("%w: %T", ErrNoAdditionalProperties, v)
+	}
+	raw, err := DefaultConfig().Marshal(value)
+	if err != nil {
+		return err
+	}
+	if fv.IsNil() {
+		fv.Set(reflect.MakeMap(fv.Type()))
+	}
+	fv.SetMapIndex(reflect.ValueOf(key).Convert(fv.Type().Key()), reflect.ValueOf(json.RawMessage(raw)).Convert(fv.Type().Elem()))
+	return nil
+}
+
+// apOptions returns the options of the extension registered with
+// DefaultConfig, which locate the AP fields found by reflection.
+func apOptions() *options {
+	if e, ok := registry.Load(DefaultConfig()); ok {
+		return e.(*additionalPropertiesExtension).Options
+	}
+	return &options{Wildcard: defaultWildcard}
+}
+
+// additionalPropertiesOf returns the AP field of the struct val, located
+// using the markers of o.
+func additionalPropertiesOf(val reflect.Value, o *options) (reflect.Value, bool) {
+	typ := reflect2.Type2(val.Type()).(reflect2.StructType)
+	marker := o.marker(typeName(typ))
+	for i := 0; i < val.NumField(); i++ {
+		f := typ.Field(i)
+		fv := val.Field(i)
+		if !fv.CanInterface() {
+			continue
+		}
+		if f.Anonymous() && f.Type() == additionalPropertiesType {
+			return fv, true
+		}
+		if isMarked(f, marker) && isRawMapType(f.Type()) {
+			return fv, true
+		}
+	}
+
+	// As with the extension, AP fields promoted from two embedded
+	// structs are ambiguous.
+	var ap reflect.Value
+	found := false
+	for _, embedded := range embeddedStructs(val.Type()) {
+		fv := val.Field(embedded.Index)
+		if !fv.CanInterface() || (embedded.Ptr && fv.IsNil()) {
+			continue
+		}
+		if embedded.Ptr {
+			fv = fv.Elem()
+		}
+		if m, ok := additionalPropertiesOf(fv, o); ok {
+			if found {
+				return reflect.Value{}, false
+			}
+			ap, found = m, true
+		}
+	}
+	return ap, found
+}
+
+// Get returns the raw JSON value of the additional property named by key
+// and whether it exists.
+func (ap AdditionalProperties) Get(key string) (json.RawMessage, bool) {
+	val, ok := ap[key]
+	return val, ok
+}
+
+// Set marshals v using DefaultConfig and stores it as the additional
+// property named by key, creating the map if it's nil.  A
+// json.RawMessage is stored as is.  The map is left unchanged if v can't
+// be marshaled.
+func (ap *AdditionalProperties) Set(key string, v interface{}) error {
+	raw, ok := v.(json.RawMessage)
+	if !ok {
+		var err error
+		if raw, err = DefaultConfig().Marshal(v); err != nil {
+			return err
+		}
+	}
+	if *ap == nil {
+		*ap = AdditionalProperties{}
+	}
+	(*ap)[key] = raw
+	return nil
+}
+
+// Has reports whether the additional property named by key exists.
+func (ap AdditionalProperties) Has(key string) bool {
+	_, ok := ap[key]
+	return ok
+}
+
+// Delete removes the additional property named by key, if it exists.
+func (ap AdditionalProperties) Delete(key string) {
+	delete(ap, key)
+}
+
+// Keys returns the names of the additional properties in lexicographic
+// order.
+func (ap AdditionalProperties) Keys() []string {
+	return sortedKeys(ap)
+}
+
+// Unmarshal decodes the additional property named by key into v using
+// DefaultConfig.  ErrPropertyNotFound is returned if the property
+// doesn't exist.
+func (ap AdditionalProperties) Unmarshal(key string, v interface{}) error {
+	val, ok := ap[key]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrPropertyNotFound, key)
+	}
+	return DefaultConfig().Unmarshal(val, v)
+}
+
+// UnmarshalInto decodes the additional property named by key into v, as
+// Unmarshal does.  Since DefaultConfig has the extension registered, v
+// may itself have additional properties, which suits decoding a property
+// into a type chosen by a sibling discriminator field.
+func (ap AdditionalProperties) UnmarshalInto(key string, v interface{}) error {
+	return ap.Unmarshal(key, v)
+}
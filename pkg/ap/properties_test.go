@@ -0,0 +1,311 @@
+package ap_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/PennState/additional-properties/pkg/ap"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Declared uses the AdditionalProperties type as a tagged field.
+type Declared struct {
+	FieldA string                  `json:"fieldA"`
+	AP     ap.AdditionalProperties `json:"*"`
+}
+
+func NewZeroDeclared() interface{} {
+	return &Declared{}
+}
+
+func NewTestDeclared() interface{} {
+	return &Declared{
+		FieldA: "Field A",
+		AP: ap.AdditionalProperties{
+			"fieldB": json.RawMessage([]byte("\"Field B\"")),
+			"fieldC": json.RawMessage([]byte("\"Field C\"")),
+		},
+	}
+}
+
+// Embedding embeds the AdditionalProperties type, which makes it the AP
+// sink without a wildcard tag.
+type Embedding struct {
+	FieldA string `json:"fieldA"`
+	ap.AdditionalProperties
+}
+
+func NewZeroEmbedding() interface{} {
+	return &Embedding{}
+}
+
+func NewTestEmbedding() interface{} {
+	return &Embedding{
+		FieldA: "Field A",
+		AdditionalProperties: ap.AdditionalProperties{
+			"fieldB": json.RawMessage([]byte("\"Field B\"")),
+			"fieldC": json.RawMessage([]byte("\"Field C\"")),
+		},
+	}
+}
+
+func TestAdditionalPropertiesMethods(t *testing.T) {
+	var e Embedding
+	require.NoError(t, e.Set("fieldB", json.RawMessage([]byte("\"Field B\""))))
+	require.NoError(t, e.Set("count", json.RawMessage([]byte("42"))))
+
+	assert.Equal(t, []string{"count", "fieldB"}, e.Keys())
+
+	val, ok := e.Get("fieldB")
+	assert.True(t, ok)
+	assert.Equal(t, "\"Field B\"", string(val))
+
+	_, ok = e.Get("missing")
+	assert.False(t, ok)
+
+	var count int
+	require.NoError(t, e.Unmarshal("count", &count))
+	assert.Equal(t, 42, count)
+
+	err := e.Unmarshal("missing", &count)
+	assert.ErrorIs(t, err, ap.ErrPropertyNotFound)
+}
+
+// Shape is decoded from an AP map using its "kind" property to choose
+// the concrete type of its "spec" property.
+type Shape struct {
+	Kind string `json:"kind"`
+	ap.AdditionalProperties
+}
+
+type Circle struct {
+	Radius float64 `json:"radius"`
+}
+
+type Square struct {
+	Side float64                    `json:"side"`
+	AP   map[string]json.RawMessage `json:"*"`
+}
+
+func TestUnmarshalInto(t *testing.T) {
+	json := ap.ConfigCompatibleWithStandardLibrary
+	data := []byte(`[{"kind":"circle","spec":{"radius":1}},{"kind":"square","spec":{"side":2,"color":"red"}}]`)
+	var shapes []Shape
+	require.NoError(t, json.Unmarshal(data, &shapes))
+	require.Len(t, shapes, 2)
+
+	specs := map[string]func() interface{}{
+		"circle": func() interface{} { return &Circle{} },
+		"square": func() interface{} { return &Square{} },
+	}
+	circle, ok := specs[shapes[0].Kind]().(*Circle)
+	require.True(t, ok)
+	require.NoError(t, shapes[0].UnmarshalInto("spec", circle))
+	assert.Equal(t, &Circle{Radius: 1}, circle)
+
+	// The target can have its own additional properties
+	square, ok := specs[shapes[1].Kind]().(*Square)
+	require.True(t, ok)
+	require.NoError(t, shapes[1].UnmarshalInto("spec", square))
+	assert.Equal(t, 2.0, square.Side)
+	assert.Equal(t, `"red"`, string(square.AP["color"]))
+
+	err := shapes[1].UnmarshalInto("missing", square)
+	assert.ErrorIs(t, err, ap.ErrPropertyNotFound)
+}
+
+// Held implements AdditionalPropertiesHolder to expose an AP map that
+// reflection wouldn't find, since it belongs to a (non-embedded) field.
+type Held struct {
+	FieldA string `json:"fieldA"`
+	Meta   Meta   `json:"meta"`
+}
+
+type Meta struct {
+	AP map[string]json.RawMessage `json:"*"`
+}
+
+func (h *Held) APMap() map[string]json.RawMessage {
+	return h.Meta.AP
+}
+
+func TestAdditionalPropertiesOf(t *testing.T) {
+	expected := NewTestSimple().(*Simple).AP
+	tests := []struct {
+		Name  string
+		Input interface{}
+		OK    bool
+	}{
+		{"Struct", *NewTestSimple().(*Simple), true},
+		{"Pointer", NewTestSimple(), true},
+		{"AdditionalProperties field", NewTestDeclared(), true},
+		{"Embedded AdditionalProperties", NewTestEmbedding(), true},
+		{"Embedded named map", NewTestEmbeddedMap(), true},
+		{"Promoted from embedded struct", NewTestFlattened(), true},
+		{"Typed AP map", NewTestReadings(), false},
+		{"No AP field", NewTestNoAP(), false},
+		{"Ambiguous", &TwoEmbedded{}, false},
+		{"Nil pointer", (*Simple)(nil), false},
+		{"Keyed raw AP map", NewTestKeyedRaw(), true},
+		{"RawMessage look-alike", NewTestRawTyped(), true},
+		{"Byte slices", &Blobs{AP: map[string][]byte{"fieldB": []byte("Field B")}}, false},
+		{"Holder", &Held{Meta: Meta{AP: expected}}, true},
+		{"Nil holder", (*Held)(nil), false},
+		{"Not a struct", expected, false},
+	}
+	for idx := range tests {
+		test := tests[idx]
+		t.Run(test.Name, func(t *testing.T) {
+			actual, ok := ap.AdditionalPropertiesOf(test.Input)
+			assert.Equal(t, test.OK, ok)
+			if test.OK {
+				assert.Equal(t, expected, actual)
+			} else {
+				assert.Nil(t, actual)
+			}
+		})
+	}
+}
+
+func TestAdditionalPropertiesOfCustomMarkers(t *testing.T) {
+	t.Cleanup(func() { ap.SetDefaultConfig(nil) })
+	expected := map[string]json.RawMessage{"fieldB": json.RawMessage(`"Field B"`)}
+	tagged := &Tagged{FieldA: "Field A", Extra: expected}
+	inline := &Inline{FieldA: "Field A", Extra: expected}
+
+	// The default "*" tag doesn't mark either field.
+	_, ok := ap.AdditionalPropertiesOf(tagged)
+	assert.False(t, ok)
+	_, ok = ap.AdditionalPropertiesOf(inline)
+	assert.False(t, ok)
+
+	// The markers of the extension registered with the default config do.
+	ap.SetDefaultConfig(ap.RegisterAdditionalPropertiesExtension(
+		jsoniter.Config{}.Froze(),
+		ap.WithWildcardTag("-extra"),
+		ap.WithTypeMarker(reflect.TypeOf(Inline{}), ",inline"),
+	))
+	for _, v := range []interface{}{tagged, inline} {
+		actual, ok := ap.AdditionalPropertiesOf(v)
+		require.True(t, ok)
+		assert.Equal(t, expected, actual)
+	}
+	_, ok = ap.AdditionalPropertiesOf(NewTestSimple())
+	assert.False(t, ok)
+}
+
+func TestSetAdditionalPropertyCustomMarkers(t *testing.T) {
+	t.Cleanup(func() { ap.SetDefaultConfig(nil) })
+	var tagged Tagged
+	var inline Inline
+	assert.ErrorIs(t, ap.SetAdditionalProperty(&tagged, "fieldB", "Field B"), ap.ErrNoAdditionalProperties)
+
+	ap.SetDefaultConfig(ap.RegisterAdditionalPropertiesExtension(
+		jsoniter.Config{}.Froze(),
+		ap.WithWildcardTag("-extra"),
+		ap.WithTypeMarker(reflect.TypeOf(Inline{}), ",inline"),
+	))
+	require.NoError(t, ap.SetAdditionalProperty(&tagged, "fieldB", "Field B"))
+	require.NoError(t, ap.SetAdditionalProperty(&inline, "fieldB", "Field B"))
+	expected := map[string]json.RawMessage{"fieldB": json.RawMessage(`"Field B"`)}
+	assert.Equal(t, expected, tagged.Extra)
+	assert.Equal(t, expected, inline.Extra)
+}
+
+func TestSetAdditionalProperty(t *testing.T) {
+	var s Simple
+	require.NoError(t, ap.SetAdditionalProperty(&s, "fieldB", "Field B"))
+	require.NoError(t, ap.SetAdditionalProperty(&s, "count", 42))
+	require.NoError(t, ap.SetAdditionalProperty(&s, "child", NewTestSimple()))
+	assert.Len(t, s.AP, 3)
+	assert.Equal(t, json.RawMessage(`"Field B"`), s.AP["fieldB"])
+	assert.Equal(t, json.RawMessage(`42`), s.AP["count"])
+	assert.JSONEq(t, `{"fieldA":"Field A","fieldB":"Field B","fieldC":"Field C"}`, string(s.AP["child"]))
+
+	var e Embedding
+	require.NoError(t, ap.SetAdditionalProperty(&e, "fieldB", "Field B"))
+	assert.Equal(t, json.RawMessage(`"Field B"`), e.AdditionalProperties["fieldB"])
+
+	var f Flattened
+	require.NoError(t, ap.SetAdditionalProperty(&f, "fieldB", "Field B"))
+	assert.Equal(t, json.RawMessage(`"Field B"`), f.AP["fieldB"])
+
+	var k KeyedRaw
+	require.NoError(t, ap.SetAdditionalProperty(&k, "fieldB", "Field B"))
+	assert.Equal(t, map[Key]json.RawMessage{"fieldB": json.RawMessage(`"Field B"`)}, k.AP)
+
+	var r RawTyped
+	require.NoError(t, ap.SetAdditionalProperty(&r, "fieldB", "Field B"))
+	assert.Equal(t, map[string]RawBytes{"fieldB": RawBytes(`"Field B"`)}, r.AP)
+
+	assert.ErrorIs(t, ap.SetAdditionalProperty(s, "fieldB", "Field B"), ap.ErrNoAdditionalProperties)
+	assert.ErrorIs(t, ap.SetAdditionalProperty(&NoAP{}, "fieldB", "Field B"), ap.ErrNoAdditionalProperties)
+	assert.Error(t, ap.SetAdditionalProperty(&s, "fieldB", make(chan int)))
+}
+
+// Extra is a named map type that can be embedded as the AP sink.
+type Extra map[string]json.RawMessage
+
+// EmbeddedMap embeds a named map type with the wildcard tag.
+type EmbeddedMap struct {
+	FieldA string `json:"fieldA"`
+	Extra  `json:"*"`
+}
+
+func NewZeroEmbeddedMap() interface{} {
+	return &EmbeddedMap{}
+}
+
+func NewTestEmbeddedMap() interface{} {
+	return &EmbeddedMap{
+		FieldA: "Field A",
+		Extra: Extra{
+			"fieldB": json.RawMessage([]byte("\"Field B\"")),
+			"fieldC": json.RawMessage([]byte("\"Field C\"")),
+		},
+	}
+}
+
+// Settings uses the AdditionalProperties type as a tagged field.
+type Settings struct {
+	Name  string                  `json:"name"`
+	Extra ap.AdditionalProperties `json:"*"`
+}
+
+func TestAdditionalPropertiesValues(t *testing.T) {
+	data := []byte(`{"name":"n","theme":"dark","size":{"width":3,"height":4}}`)
+	var s Settings
+	require.NoError(t, ap.Unmarshal(data, &s))
+	assert.Equal(t, "n", s.Name)
+	assert.True(t, s.Extra.Has("theme"))
+	assert.False(t, s.Extra.Has("name"))
+
+	var theme string
+	require.NoError(t, s.Extra.Unmarshal("theme", &theme))
+	assert.Equal(t, "dark", theme)
+
+	var size struct{ Width, Height int }
+	require.NoError(t, s.Extra.Unmarshal("size", &size))
+	assert.Equal(t, 3, size.Width)
+	assert.Equal(t, 4, size.Height)
+
+	err := s.Extra.Unmarshal("missing", &theme)
+	assert.ErrorIs(t, err, ap.ErrPropertyNotFound)
+
+	s.Extra.Delete("size")
+	s.Extra.Delete("missing")
+	require.NoError(t, s.Extra.Set("count", 42))
+	require.Error(t, s.Extra.Set("invalid", make(chan int)))
+	assert.False(t, s.Extra.Has("invalid"))
+
+	actual, err := ap.Marshal(s)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"name":"n","theme":"dark","count":42}`, string(actual))
+
+	var props ap.AdditionalProperties
+	require.NoError(t, props.Set("key", "value"))
+	assert.Equal(t, ap.AdditionalProperties{"key": json.RawMessage(`"value"`)}, props)
+}
@@ -0,0 +1,85 @@
+package ap_test
+
+import (
+	"testing"
+
+	"github.com/PennState/additional-properties/pkg/ap"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// RawBytes mimics a vendored copy of json.RawMessage.
+type RawBytes []byte
+
+func (r RawBytes) MarshalJSON() ([]byte, error) {
+	if r == nil {
+		return []byte("null"), nil
+	}
+	return r, nil
+}
+
+func (r *RawBytes) UnmarshalJSON(data []byte) error {
+	*r = append((*r)[0:0], data...)
+	return nil
+}
+
+// RawTyped uses a RawMessage look-alike for the AP map values.
+type RawTyped struct {
+	FieldA string              `json:"fieldA"`
+	AP     map[string]RawBytes `json:"*"`
+}
+
+func NewZeroRawTyped() interface{} {
+	return &RawTyped{}
+}
+
+func NewTestRawTyped() interface{} {
+	return &RawTyped{
+		FieldA: "Field A",
+		AP: map[string]RawBytes{
+			"fieldB": RawBytes([]byte("\"Field B\"")),
+			"fieldC": RawBytes([]byte("\"Field C\"")),
+		},
+	}
+}
+
+// NotAMap has a wildcard field that can't hold additional properties,
+// so it's treated as a regular field.
+type NotAMap struct {
+	FieldA string `json:"fieldA"`
+	AP     string `json:"*"`
+}
+
+func TestWildcardFieldMustBeRawMap(t *testing.T) {
+	json := ap.ConfigCompatibleWithStandardLibrary
+	var v NotAMap
+	require.NoError(t, json.Unmarshal([]byte(`{"fieldA":"Field A","fieldB":"Field B"}`), &v))
+	assert.Equal(t, NotAMap{FieldA: "Field A"}, v)
+}
+
+// Blobs has an AP map of byte slices, which hold base64-encoded values
+// rather than raw JSON.
+type Blobs struct {
+	FieldA string            `json:"fieldA"`
+	AP     map[string][]byte `json:"*"`
+}
+
+func TestByteSliceAPMapRoundTrip(t *testing.T) {
+	data := []byte(`{"fieldA":"Field A","fieldB":"aGVsbG8="}`)
+	for name, api := range map[string]jsoniter.API{
+		"Default":    ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze()),
+		"Compatible": ap.ConfigCompatibleWithStandardLibrary,
+	} {
+		api := api
+		t.Run(name, func(t *testing.T) {
+			var b Blobs
+			require.NoError(t, api.Unmarshal(data, &b))
+			assert.Equal(t, Blobs{FieldA: "Field A", AP: map[string][]byte{"fieldB": []byte("hello")}}, b)
+
+			actual, err := api.Marshal(b)
+			require.NoError(t, err)
+			assert.JSONEq(t, string(data), string(actual))
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package ap_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/PennState/additional-properties/pkg/ap"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Node is recursive, so jsoniter decorates the codecs of its children
+// while it's still describing Node itself.
+type Node struct {
+	Name     string                     `json:"name"`
+	Children []Node                     `json:"children,omitempty"`
+	Parent   *Node                      `json:"parent,omitempty"`
+	AP       map[string]json.RawMessage `json:"*"`
+}
+
+func TestRecursiveType(t *testing.T) {
+	api := ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze(), ap.WithDeterministicOutput())
+	data := []byte(`{"name":"root","children":[{"name":"child","parent":{"name":"up","x":3},"y":2}],"z":1}`)
+
+	var n Node
+	require.NoError(t, api.Unmarshal(data, &n))
+	assert.Equal(t, Node{
+		Name: "root",
+		Children: []Node{{
+			Name: "child",
+			Parent: &Node{
+				Name: "up",
+				AP:   map[string]json.RawMessage{"x": json.RawMessage(`3`)},
+			},
+			AP: map[string]json.RawMessage{"y": json.RawMessage(`2`)},
+		}},
+		AP: map[string]json.RawMessage{"z": json.RawMessage(`1`)},
+	}, n)
+
+	actual, err := api.Marshal(n)
+	require.NoError(t, err)
+	assert.Equal(t, string(data), string(actual))
+}
+
+// Tree is recursive through a map and gets its AP field from an embedded
+// struct, so the embedded AP binding is found while the children's codecs
+// are still being decorated.
+type Tree struct {
+	Simple
+	Kids map[string]*Tree `json:"kids,omitempty"`
+}
+
+func TestRecursiveTypeWithEmbeddedAP(t *testing.T) {
+	data := []byte(`{"fieldA":"a","kids":{"k":{"fieldA":"b","kids":{"l":{"fieldA":"c","z":3}},"y":2}},"x":1}`)
+	// The second iteration decodes and encodes using the codecs cached by
+	// the first.
+	api := ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze(), ap.WithDeterministicOutput())
+	for i := 0; i < 2; i++ {
+		var tree Tree
+		require.NoError(t, api.Unmarshal(data, &tree))
+		assert.Equal(t, json.RawMessage(`1`), tree.AP["x"])
+		require.Contains(t, tree.Kids, "k")
+		assert.Equal(t, json.RawMessage(`2`), tree.Kids["k"].AP["y"])
+		require.Contains(t, tree.Kids["k"].Kids, "l")
+		assert.Equal(t, "c", tree.Kids["k"].Kids["l"].FieldA)
+		assert.Equal(t, json.RawMessage(`3`), tree.Kids["k"].Kids["l"].AP["z"])
+
+		actual, err := api.Marshal(tree)
+		require.NoError(t, err)
+		assert.Equal(t, string(data), string(actual))
+	}
+}
@@ -0,0 +1,98 @@
+package ap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"unsafe"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/modern-go/reflect2"
+)
+
+// bindingSample is decoded by SelfCheck to inspect the bindings that
+// jsoniter creates for a struct with an AP field.
+type bindingSample struct {
+	Field string                     `json:"field"`
+	AP    map[string]json.RawMessage `json:"*"`
+}
+
+//nolint:gochecknoglobals
+var bindingSampleType = reflect2.TypeOf(bindingSample{})
+
+// bindingProbe captures the descriptor jsoniter creates for bindingSample.
+type bindingProbe struct {
+	jsoniter.DummyExtension
+	Desc *jsoniter.StructDescriptor
+}
+
+func (p *bindingProbe) UpdateStructDescriptor(desc *jsoniter.StructDescriptor) {
+	if desc.Type == bindingSampleType {
+		p.Desc = desc
+	}
+}
+
+//nolint:gochecknoglobals
+var (
+	selfCheckOnce sync.Once
+	selfCheckErr  error
+)
+
+// SelfCheck verifies that the jsoniter.Binding fields the extension
+// relies on (FromNames, ToNames, Field, Decoder and Encoder) behave as
+// expected with the linked version of jsoniter.  The check runs once, on
+// first use of the extension.  If it fails, marshaling or unmarshaling a
+// struct with an AP field fails with the error, which each extension
+// logs using its logger, rather than silently misbehaving.
+func SelfCheck() error {
+	selfCheckOnce.Do(func() {
+		selfCheckErr = checkBindings()
+	})
+	return selfCheckErr
+}
+
+func checkBindings() error {
+	probe := &bindingProbe{}
+	api := jsoniter.Config{}.Froze()
+	api.RegisterExtension(probe)
+
+	var sample bindingSample
+	if err := api.Unmarshal([]byte(`{"field":"value"}`), &sample); err != nil {
+		return fmt.Errorf("jsoniter self-check: %w", err)
+	}
+	if probe.Desc == nil {
+		return errors.New("jsoniter self-check: UpdateStructDescriptor wasn't called")
+	}
+
+	var field, wildcard *jsoniter.Binding
+	for _, binding := range probe.Desc.Fields {
+		if binding.Field == nil {
+			return errors.New("jsoniter self-check: binding has no Field")
+		}
+		switch binding.Field.Name() {
+		case "Field":
+			field = binding
+		case "AP":
+			wildcard = binding
+		}
+	}
+	if field == nil || wildcard == nil {
+		return errors.New("jsoniter self-check: missing field bindings")
+	}
+	if !hasNames(field, "field") || !hasNames(wildcard, "*") {
+		return errors.New("jsoniter self-check: unexpected FromNames or ToNames")
+	}
+	if field.Decoder == nil || field.Encoder == nil {
+		return errors.New("jsoniter self-check: binding has no Decoder or Encoder")
+	}
+	if *(*string)(field.Field.UnsafeGet(unsafe.Pointer(&sample))) != "value" {
+		return errors.New("jsoniter self-check: binding Field doesn't address the struct field")
+	}
+	return nil
+}
+
+func hasNames(binding *jsoniter.Binding, name string) bool {
+	return len(binding.FromNames) == 1 && binding.FromNames[0] == name &&
+		len(binding.ToNames) == 1 && binding.ToNames[0] == name
+}
@@ -0,0 +1,12 @@
+package ap_test
+
+import (
+	"testing"
+
+	"github.com/PennState/additional-properties/pkg/ap"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelfCheck(t *testing.T) {
+	assert.NoError(t, ap.SelfCheck())
+}
@@ -0,0 +1,58 @@
+package ap_test
+
+import "encoding/json"
+
+func newTestSimpleElement(a, b string) Simple {
+	return Simple{
+		FieldA: a,
+		AP: map[string]json.RawMessage{
+			"fieldB": json.RawMessage([]byte("\"" + b + "\"")),
+		},
+	}
+}
+
+func NewZeroSimpleSlice() interface{} {
+	return &[]Simple{}
+}
+
+func NewTestSimpleSlice() interface{} {
+	return &[]Simple{
+		newTestSimpleElement("Field A1", "Field B1"),
+		newTestSimpleElement("Field A2", "Field B2"),
+	}
+}
+
+func NewZeroSimpleArray() interface{} {
+	return &[2]Simple{}
+}
+
+func NewTestSimpleArray() interface{} {
+	return &[2]Simple{
+		newTestSimpleElement("Field A1", "Field B1"),
+		newTestSimpleElement("Field A2", "Field B2"),
+	}
+}
+
+func NewZeroSimpleMap() interface{} {
+	return &map[string]Simple{}
+}
+
+func NewTestSimpleMap() interface{} {
+	return &map[string]Simple{
+		"a": newTestSimpleElement("Field A1", "Field B1"),
+		"b": newTestSimpleElement("Field A2", "Field B2"),
+	}
+}
+
+func NewZeroSimplePtrMap() interface{} {
+	return &map[string]*Simple{}
+}
+
+func NewTestSimplePtrMap() interface{} {
+	a := newTestSimpleElement("Field A1", "Field B1")
+	b := newTestSimpleElement("Field A2", "Field B2")
+	return &map[string]*Simple{
+		"a": &a,
+		"b": &b,
+	}
+}
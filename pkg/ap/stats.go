@@ -0,0 +1,108 @@
+package ap
+
+import (
+	"sync/atomic"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// Stats is a snapshot of the counters maintained by the AP extension
+// when it's registered using WithStats.
+type Stats struct {
+	// DecoratedDecoders and DecoratedEncoders are the numbers of struct
+	// decoders and encoders created for types with an AP field.
+	DecoratedDecoders int64
+	DecoratedEncoders int64
+	// DescHits and DescMisses count the descriptor cache lookups made
+	// while decorating, where a miss defers the decoration.
+	DescHits   int64
+	DescMisses int64
+	// APBindingHits and APBindingMisses count the AP binding cache
+	// lookups made while decorating, where a miss means the type has no
+	// AP field.
+	APBindingHits   int64
+	APBindingMisses int64
+	// AdditionalProperties is the total number of additional properties
+	// captured while decoding.
+	AdditionalProperties int64
+}
+
+// stats holds the counters behind Stats, which are updated atomically.
+type stats struct {
+	Stats
+}
+
+// The methods of stats are no-ops on a nil receiver, which is how the
+// counters are disabled.
+
+func (s *stats) decorated(decoder bool) {
+	switch {
+	case s == nil:
+	case decoder:
+		atomic.AddInt64(&s.DecoratedDecoders, 1)
+	default:
+		atomic.AddInt64(&s.DecoratedEncoders, 1)
+	}
+}
+
+func (s *stats) descLookup(hit bool) {
+	switch {
+	case s == nil:
+	case hit:
+		atomic.AddInt64(&s.DescHits, 1)
+	default:
+		atomic.AddInt64(&s.DescMisses, 1)
+	}
+}
+
+func (s *stats) apBindingLookup(hit bool) {
+	switch {
+	case s == nil:
+	case hit:
+		atomic.AddInt64(&s.APBindingHits, 1)
+	default:
+		atomic.AddInt64(&s.APBindingMisses, 1)
+	}
+}
+
+func (s *stats) captured(n int64) {
+	if s != nil {
+		atomic.AddInt64(&s.AdditionalProperties, n)
+	}
+}
+
+func (s *stats) snapshot() Stats {
+	return Stats{
+		DecoratedDecoders:    atomic.LoadInt64(&s.DecoratedDecoders),
+		DecoratedEncoders:    atomic.LoadInt64(&s.DecoratedEncoders),
+		DescHits:             atomic.LoadInt64(&s.DescHits),
+		DescMisses:           atomic.LoadInt64(&s.DescMisses),
+		APBindingHits:        atomic.LoadInt64(&s.APBindingHits),
+		APBindingMisses:      atomic.LoadInt64(&s.APBindingMisses),
+		AdditionalProperties: atomic.LoadInt64(&s.AdditionalProperties),
+	}
+}
+
+// WithStats enables the counters reported by StatsOf, for profiling the
+// extension.  The counters are updated atomically, so they don't add
+// lock contention, and aren't updated at all unless this is set.
+func WithStats() Option {
+	return func(o *options) {
+		o.Stats = &stats{}
+	}
+}
+
+// StatsOf returns a snapshot of the counters of the AP extension
+// registered with api, returning false if there isn't one or it wasn't
+// registered using WithStats.
+func StatsOf(api jsoniter.API) (Stats, bool) {
+	e, ok := registry.Load(api)
+	if !ok {
+		return Stats{}, false
+	}
+	s := e.(*additionalPropertiesExtension).Options.Stats
+	if s == nil {
+		return Stats{}, false
+	}
+	return s.snapshot(), true
+}
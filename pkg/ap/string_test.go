@@ -0,0 +1,22 @@
+package ap_test
+
+import "encoding/json"
+
+// Counted has a numeric field that's quoted using the string qualifier.
+type Counted struct {
+	Count int                        `json:"count,string"`
+	AP    map[string]json.RawMessage `json:"*"`
+}
+
+func NewZeroCounted() interface{} {
+	return &Counted{}
+}
+
+func NewTestCounted() interface{} {
+	return &Counted{
+		Count: 42,
+		AP: map[string]json.RawMessage{
+			"fieldB": json.RawMessage([]byte("\"Field B\"")),
+		},
+	}
+}
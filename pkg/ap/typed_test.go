@@ -0,0 +1,112 @@
+package ap_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Celsius customizes its JSON representation.
+type Celsius float64
+
+func (c Celsius) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf("\"%.1fC\"", float64(c))), nil
+}
+
+func (c *Celsius) UnmarshalJSON(data []byte) error {
+	s, err := strconv.Unquote(string(data))
+	if err != nil {
+		return err
+	}
+	f, err := strconv.ParseFloat(strings.TrimSuffix(s, "C"), 64)
+	if err != nil {
+		return err
+	}
+	*c = Celsius(f)
+	return nil
+}
+
+// Readings has a typed AP map whose values have custom marshaling.
+type Readings struct {
+	Station string             `json:"station"`
+	AP      map[string]Celsius `json:"*"`
+}
+
+func NewZeroReadings() interface{} {
+	return &Readings{}
+}
+
+func NewTestReadings() interface{} {
+	return &Readings{
+		Station: "North",
+		AP: map[string]Celsius{
+			"morning": 12.5,
+			"evening": 8,
+		},
+	}
+}
+
+// Stations has a typed AP map whose values are pointers to structs with
+// their own AP fields.
+type Stations struct {
+	Region string             `json:"region"`
+	AP     map[string]*Simple `json:"*"`
+}
+
+func NewZeroStations() interface{} {
+	return &Stations{}
+}
+
+func NewTestStations() interface{} {
+	return &Stations{
+		Region: "East",
+		AP: map[string]*Simple{
+			"north":  NewTestSimple().(*Simple),
+			"closed": nil,
+		},
+	}
+}
+
+// Key is a defined string type for AP map keys.
+type Key string
+
+// KeyedReadings has a typed AP map whose keys have a defined string type.
+type KeyedReadings struct {
+	Station string          `json:"station"`
+	AP      map[Key]Celsius `json:"*"`
+}
+
+func NewZeroKeyedReadings() interface{} {
+	return &KeyedReadings{}
+}
+
+func NewTestKeyedReadings() interface{} {
+	return &KeyedReadings{
+		Station: "North",
+		AP: map[Key]Celsius{
+			"morning": 12.5,
+			"evening": 8,
+		},
+	}
+}
+
+// KeyedRaw has a raw AP map whose keys have a defined string type.
+type KeyedRaw struct {
+	FieldA string                  `json:"fieldA"`
+	AP     map[Key]json.RawMessage `json:"*"`
+}
+
+func NewZeroKeyedRaw() interface{} {
+	return &KeyedRaw{}
+}
+
+func NewTestKeyedRaw() interface{} {
+	return &KeyedRaw{
+		FieldA: "Field A",
+		AP: map[Key]json.RawMessage{
+			"fieldB": json.RawMessage(`"Field B"`),
+			"fieldC": json.RawMessage(`"Field C"`),
+		},
+	}
+}
@@ -0,0 +1,110 @@
+package ap
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+
+	"github.com/modern-go/reflect2"
+)
+
+// ValidationError is returned by Validate, and holds an error for each
+// struct type with an unsupported AP declaration.
+type ValidationError struct {
+	Errors []error
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	return "invalid additional-properties declarations: " + strings.Join(msgs, "; ")
+}
+
+// Is reports whether any of the errors matches target, so that errors.Is
+// matches the error of each type.
+func (e *ValidationError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first of the errors that matches target, so that errors.As
+// matches the error of each type.
+func (e *ValidationError) As(target interface{}) bool {
+	for _, err := range e.Errors {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate resolves the decoders and encoders of the types of the passed
+// values using DefaultConfig, as their first unmarshal and marshal would,
+// so services can fail fast at startup rather than at first use.  A
+// *ValidationError is returned if any struct type reachable from them is
+// misconfigured (see ErrMultipleWildcards, ErrUnsupportedAPType,
+// ErrUnexportedWildcard and ErrInvalidValueField) or has a wildcard field
+// that's ignored (ErrWildcardNotMap), with an error for each such type.
+func Validate(types ...interface{}) error {
+	api := DefaultConfig()
+	v, ok := registry.Load(api)
+	if !ok {
+		return nil
+	}
+	e := v.(*additionalPropertiesExtension)
+
+	seen := map[reflect.Type]bool{}
+	var structs []reflect.Type
+	for _, t := range types {
+		typ := reflect2.TypeOf(t)
+		if typ == nil {
+			continue
+		}
+		api.DecoderOf(reflect2.PtrTo(typ))
+		api.EncoderOf(typ)
+		structs = reachableStructs(typ.Type1(), seen, structs)
+	}
+
+	e.Mutex.Lock()
+	defer e.Mutex.Unlock()
+	var errs []error
+	for _, typ := range structs {
+		name := typeName(reflect2.Type2(typ))
+		if err := e.Errors[name]; err != nil {
+			errs = append(errs, err)
+		}
+		if err := e.Warnings[name]; err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// reachableStructs appends the struct types reachable from typ (through
+// pointers, elements and fields, as jsoniter builds their codecs) that
+// haven't been seen yet to structs.
+func reachableStructs(typ reflect.Type, seen map[reflect.Type]bool, structs []reflect.Type) []reflect.Type {
+	if seen[typ] {
+		return structs
+	}
+	seen[typ] = true
+	switch typ.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return reachableStructs(typ.Elem(), seen, structs)
+	case reflect.Struct:
+		structs = append(structs, typ)
+		for i := 0; i < typ.NumField(); i++ {
+			structs = reachableStructs(typ.Field(i).Type, seen, structs)
+		}
+	}
+	return structs
+}
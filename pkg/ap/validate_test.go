@@ -0,0 +1,138 @@
+package ap_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/PennState/additional-properties/pkg/ap"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// RawField holds a raw value in a named field, for comparison with how
+// raw additional properties are re-encoded.
+type RawField struct {
+	Raw json.RawMessage `json:"raw"`
+}
+
+func TestRawValuesRoundTrip(t *testing.T) {
+	json := ap.ConfigCompatibleWithStandardLibrary
+	data := []byte(`{"obj":{"a":[1,2,{"b":null}]},"num":-1.5e3,"str":"x\"y","bool":true,"nil":null}`)
+	var v APOnly
+	require.NoError(t, json.Unmarshal(data, &v))
+	actual, err := json.Marshal(v)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(data), string(actual))
+}
+
+func TestMalformedRawValues(t *testing.T) {
+	malformed := json.RawMessage(`{"a":`)
+	v := APOnly{
+		AP: map[string]json.RawMessage{"raw": malformed},
+	}
+
+	// Validated like a named json.RawMessage field - invalid values are
+	// written as null
+	api := ap.ConfigCompatibleWithStandardLibrary
+	actual, err := api.Marshal(v)
+	require.NoError(t, err)
+	assert.Equal(t, `{"raw":null}`, string(actual))
+	expected, err := api.Marshal(RawField{Raw: malformed})
+	require.NoError(t, err)
+	assert.Equal(t, string(expected), string(actual))
+
+	// Without ValidateJsonRawMessage the bytes are written verbatim
+	api = ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze())
+	actual, err = api.Marshal(v)
+	require.NoError(t, err)
+	assert.Equal(t, `{"raw":{"a":}`, string(actual))
+}
+
+func TestNumberLiteralsRoundTrip(t *testing.T) {
+	literals := []string{
+		`1e3`, `1E3`, `1e+3`, `1e-3`, `1.0`, `1.500`, `0.000`, `-0`,
+		`-0.0e0`, `123456789012345678901234567890`, `1.7976931348623157e309`,
+		`5e-324`, `0.1000000000000000055511151231257827`,
+	}
+	apis := map[string]jsoniter.API{
+		"Validated":   ap.ConfigCompatibleWithStandardLibrary,
+		"Unvalidated": ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze()),
+	}
+	for name, api := range apis {
+		api := api
+		t.Run(name, func(t *testing.T) {
+			for _, literal := range literals {
+				data := []byte(`{"num":` + literal + `}`)
+				var v APOnly
+				require.NoError(t, api.Unmarshal(data, &v))
+				assert.Equal(t, literal, string(v.AP["num"]))
+
+				actual, err := api.Marshal(v)
+				require.NoError(t, err)
+				assert.Equal(t, string(data), string(actual))
+			}
+		})
+	}
+}
+
+func TestRawValueWhitespace(t *testing.T) {
+	data := []byte("{\"x\":   {  \"a\" :1 } , \"n\" :  12  ,\"s\":\n\"s\"\n}")
+	apis := map[string]jsoniter.API{
+		"Validated":   ap.ConfigCompatibleWithStandardLibrary,
+		"Unvalidated": ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze()),
+		"Interned":    ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze(), ap.WithValueInterning()),
+	}
+
+	// Captured the same as by encoding/json
+	var expected map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(data, &expected))
+
+	for name, api := range apis {
+		api := api
+		t.Run(name, func(t *testing.T) {
+			var v APOnly
+			require.NoError(t, api.Unmarshal(data, &v))
+			assert.Equal(t, expected, v.AP)
+
+			// Re-encoded verbatim, like a named json.RawMessage field
+			actual, err := api.Marshal(APOnly{AP: map[string]json.RawMessage{"x": v.AP["x"]}})
+			require.NoError(t, err)
+			named, err := api.Marshal(RawField{Raw: v.AP["x"]})
+			require.NoError(t, err)
+			assert.Equal(t, `{"x":{  "a" :1 }}`, string(actual))
+			assert.Equal(t, `{"raw":{  "a" :1 }}`, string(named))
+		})
+	}
+
+	// Normalized on request
+	var v APOnly
+	require.NoError(t, ap.Unmarshal(data, &v))
+	actual, err := ap.MarshalJCS(v)
+	require.NoError(t, err)
+	assert.Equal(t, `{"n":12,"s":"s","x":{"a":1}}`, string(actual))
+}
+
+func TestRawPassthroughMatchesRawMessageEncoder(t *testing.T) {
+	// Without validation or HTML escaping, raw values bypass the API's
+	// json.RawMessage encoder, but are written just as it writes them.
+	api := ap.RegisterAdditionalPropertiesExtension(jsoniter.Config{}.Froze())
+	values := []json.RawMessage{
+		nil,
+		json.RawMessage(``),
+		json.RawMessage(`1`),
+		json.RawMessage(`-1.5e3`),
+		json.RawMessage(`1e400`),
+		json.RawMessage(`"<b>"`),
+		json.RawMessage(`{  "a" :[1,2] }`),
+		json.RawMessage(` null `),
+		json.RawMessage(`{"a":`),
+	}
+	for _, raw := range values {
+		expected, err := api.Marshal(RawField{Raw: raw})
+		require.NoError(t, err)
+		actual, err := api.Marshal(APOnly{AP: map[string]json.RawMessage{"raw": raw}})
+		require.NoError(t, err)
+		assert.Equal(t, string(expected), string(actual), string(raw))
+	}
+}